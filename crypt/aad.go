@@ -0,0 +1,49 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import "sort"
+
+//AAD is additional authenticated data, i.e. context such as the environment
+//or file name, that's bound into a ciphertext without being stored in it. The
+//same AAD must be supplied to decrypt the ciphertext.
+type AAD map[string]string
+
+//UnmarshalFlag adds a key=value pair to the AAD, so the --aad flag can be
+//repeated
+func (a *AAD) UnmarshalFlag(value string) error {
+	return addKeyValue((*map[string]string)(a), "AAD", value)
+}
+
+//bytes returns the canonical encoding of the AAD, authenticated by GCM, see
+//encodeKeyValues. Empty AAD encodes as nil.
+func (a AAD) bytes() []byte {
+	return encodeKeyValues(a)
+}
+
+//keys returns the sorted keys of the AAD, or nil if it's empty
+func (a AAD) keys() (keys []string) {
+	for key := range a {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+//additionalData returns the data authenticated alongside a ciphertext, i.e.
+//its raw header followed by the encoded AAD
+func additionalData(rawHeader []byte, aad AAD) []byte {
+	return append(append([]byte{}, rawHeader...), aad.bytes()...)
+}
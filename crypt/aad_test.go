@@ -0,0 +1,127 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+func TestAADUnmarshalFlag(t *testing.T) {
+	var aad AAD
+	for _, value := range []string{"env=prod", "file=db.yaml", "empty=", "url=a=b"} {
+		if err := aad.UnmarshalFlag(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := AAD{"env": "prod", "file": "db.yaml", "empty": "", "url": "a=b"}
+	if !reflect.DeepEqual(aad, want) {
+		t.Errorf("Got %v, want %v", aad, want)
+	}
+	for _, value := range []string{"env", "=prod", "env=staging"} {
+		if err := aad.UnmarshalFlag(value); err == nil {
+			t.Errorf("Expected error for AAD %q", value)
+		}
+	}
+}
+
+func TestAADFlag(t *testing.T) {
+	var opts Defaults
+	_, err := flags.NewParser(&opts, flags.None).ParseArgs([]string{
+		"--aad", "env=prod", "--aad", "file=db.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AAD{"env": "prod", "file": "db.yaml"}
+	if !reflect.DeepEqual(opts.AAD, want) {
+		t.Errorf("Got %v, want %v", opts.AAD, want)
+	}
+}
+
+func TestAADBytes(t *testing.T) {
+	if AAD(nil).bytes() != nil {
+		t.Error("Expected empty AAD to encode as nil")
+	}
+	// the length prefixes stop the key/value boundary being moved
+	if bytes.Equal(AAD{"ab": "c"}.bytes(), AAD{"a": "bc"}.bytes()) {
+		t.Error("Expected different AAD to encode differently")
+	}
+	aad := AAD{"b": "2", "a": "1", "c": "3"}
+	for i := 0; i < 10; i++ {
+		if !bytes.Equal(aad.bytes(), AAD{"a": "1", "b": "2", "c": "3"}.bytes()) {
+			t.Fatal("Expected AAD to encode in key order")
+		}
+	}
+}
+
+var aadTests = []struct {
+	name    string
+	decrypt AAD
+	wantErr bool
+}{
+	{"matching", AAD{"file": "db.yaml", "env": "prod"}, false},
+	{"different value", AAD{"env": "staging", "file": "db.yaml"}, true},
+	{"missing key", AAD{"env": "prod"}, true},
+	{"extra key", AAD{"env": "prod", "file": "db.yaml", "team": "a"}, true},
+	{"none", nil, true},
+}
+
+func TestCipherBytesWithAAD(t *testing.T) {
+	plaintext := []byte("helloworld")
+	aad := AAD{"env": "prod", "file": "db.yaml"}
+	cipherBytes, err := CipherBytesFromPrimitives(plaintext, true, false,
+		stubKms{}, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(string(cipherBytes))
+	for _, test := range aadTests {
+		result, err := PlainTextFromPrimitives(decoded, stubKms{}, test.decrypt)
+		checkAADResult(t, test.name, result, plaintext, err, test.wantErr)
+	}
+}
+
+func TestStreamWithAAD(t *testing.T) {
+	plaintext, _ := randByteSlice(2*defaultChunkSize + 1)
+	var cipherBuf bytes.Buffer
+	err := EncryptStream(&cipherBuf, bytes.NewReader(plaintext), true, stubKms{},
+		AAD{"env": "prod", "file": "db.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range aadTests {
+		var plainBuf bytes.Buffer
+		err = DecryptStream(&plainBuf, bytes.NewReader(cipherBuf.Bytes()),
+			stubKms{}, test.decrypt)
+		checkAADResult(t, test.name, plainBuf.Bytes(), plaintext, err, test.wantErr)
+	}
+}
+
+//checkAADResult checks decryption with the AAD failed authentication, or
+//returned the plaintext, as expected
+func checkAADResult(t *testing.T, name string, result, plaintext []byte,
+	err error, wantErr bool) {
+	if wantErr && !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("%s AAD: got %v, want %v", name, err, ErrAuthenticationFailed)
+	}
+	if !wantErr && (err != nil || !bytes.Equal(result, plaintext)) {
+		t.Errorf("%s AAD: decryption failed (%v)", name, err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+func init() {
+	mustRegisterProvider("AGE", newAgeKms)
+}
+
+//ageIdentityEnvVar holds age identities, used when there's no identity file
+const ageIdentityEnvVar = "MANTLE_AGE_IDENTITY"
+
+//AgeKms wraps DEKs to one or more age X25519 recipients (age1... public
+//keys), so encrypting only needs the public keys, while decrypting needs one
+//of the matching identities (AGE-SECRET-KEY-1... private keys). The encrypted
+//DEK is a binary age file.
+type AgeKms struct {
+	//Recipients the DEK is wrapped to when encrypting
+	Recipients []age.Recipient
+	//Identities tried when decrypting
+	Identities []age.Identity
+}
+
+//newAgeKms creates an AgeKms from the ageRecipient, ageRecipientsFile and
+//ageIdentityFile flags, and the MANTLE_AGE_IDENTITY environment variable
+func newAgeKms(opts Defaults) (KmsProvider, error) {
+	recipients, err := ageRecipients(opts)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := ageIdentities(opts.AgeIdentityFile)
+	return AgeKms{Recipients: recipients, Identities: identities}, err
+}
+
+//ageRecipients parses the recipients given by the flags, and in the
+//recipients file, which has one recipient per line, ignoring # comments
+func ageRecipients(opts Defaults) ([]age.Recipient, error) {
+	recipients := strings.Join(opts.AgeRecipients, "\n")
+	if opts.AgeRecipientsFile != "" {
+		file, err := ioutil.ReadFile(opts.AgeRecipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		recipients += "\n" + string(file)
+	}
+	if strings.TrimSpace(recipients) == "" {
+		return nil, nil
+	}
+	return age.ParseRecipients(strings.NewReader(recipients))
+}
+
+//ageIdentities parses the identities in the file, or the MANTLE_AGE_IDENTITY
+//environment variable
+func ageIdentities(identityFile string) ([]age.Identity, error) {
+	var identities io.Reader = strings.NewReader(os.Getenv(ageIdentityEnvVar))
+	if identityFile != "" {
+		file, err := os.Open(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		identities = file
+	} else if os.Getenv(ageIdentityEnvVar) == "" {
+		return nil, nil
+	}
+	return age.ParseIdentities(identities)
+}
+
+//Name returns "AGE"
+func (a AgeKms) Name() string {
+	return "AGE"
+}
+
+//Encrypt wraps the DEK to every recipient
+func (a AgeKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte,
+	err error) {
+	if len(a.Recipients) == 0 {
+		return nil, kmsError(a.Name(), true, errors.New("no age recipients given"))
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, a.Recipients...)
+	if err == nil {
+		if _, err = w.Write(dek); err == nil {
+			err = w.Close()
+		}
+	}
+	return buf.Bytes(), kmsError(a.Name(), true, err)
+}
+
+func (a AgeKms) canDecrypt() bool {
+	return len(a.Identities) > 0
+}
+
+//Decrypt unwraps the DEK with whichever identity it was wrapped to
+func (a AgeKms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte,
+	err error) {
+	if len(a.Identities) == 0 {
+		return nil, kmsError(a.Name(), false, errors.New("no age identities given"))
+	}
+	r, err := age.Decrypt(bytes.NewReader(encryptedDek), a.Identities...)
+	if err == nil {
+		dek, err = ioutil.ReadAll(r)
+	}
+	return dek, kmsError(a.Name(), false, err)
+}
@@ -0,0 +1,132 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+//newTestAgeIdentity returns a new age identity
+func newTestAgeIdentity(t *testing.T) *age.X25519Identity {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func TestAgeKms(t *testing.T) {
+	alice, bob, eve := newTestAgeIdentity(t), newTestAgeIdentity(t),
+		newTestAgeIdentity(t)
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, AgeKms{
+		Recipients: []age.Recipient{alice.Recipient(), bob.Recipient()}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range []*age.X25519Identity{alice, bob} {
+		result, err := PlainTextFromPrimitives(sealed,
+			AgeKms{Identities: []age.Identity{identity}}, nil)
+		if err != nil || !bytes.Equal(result, plaintext) {
+			t.Errorf("Decryption with %s failed (%v)", identity.Recipient(), err)
+		}
+	}
+	_, err = PlainTextFromPrimitives(sealed,
+		AgeKms{Identities: []age.Identity{eve}}, nil)
+	if !errors.Is(err, ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v", err, ErrKMSUnavailable)
+	}
+}
+
+func TestAgeKmsWithoutKeys(t *testing.T) {
+	if _, err := (AgeKms{}).Encrypt(context.Background(), []byte("dek")); err == nil {
+		t.Error("Expected error encrypting without recipients")
+	}
+	if _, err := (AgeKms{}).Decrypt(context.Background(), []byte("dek")); err == nil {
+		t.Error("Expected error decrypting without identities")
+	}
+}
+
+func TestAgeKmsRecipientsOnly(t *testing.T) {
+	alice := newTestAgeIdentity(t)
+	plaintext := []byte("helloworld")
+	// the ciphertext can't be validated without an identity, so isn't
+	cipherBytes, err := CipherBytesFromPrimitives(plaintext, false, false,
+		AgeKms{Recipients: []age.Recipient{alice.Recipient()}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(string(cipherBytes))
+	result, err := PlainTextFromPrimitives(sealed,
+		AgeKms{Identities: []age.Identity{alice}}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Got %s (%v), want %s", result, err, plaintext)
+	}
+}
+
+func TestCanDecryptWithoutAgeIdentity(t *testing.T) {
+	kmsProviders := []KmsProvider{AgeKms{}, stubKms{}}
+	if canDecrypt(AgeKms{}) || !canDecrypt(MultiKms{Providers: kmsProviders}) ||
+		canDecrypt(ThresholdKms{Threshold: 2, Providers: kmsProviders}) {
+		t.Error("Expected only the MultiKms to decrypt without an age identity")
+	}
+}
+
+func TestNewAgeKms(t *testing.T) {
+	alice, bob := newTestAgeIdentity(t), newTestAgeIdentity(t)
+	dir := t.TempDir()
+	recipientsFile := filepath.Join(dir, "recipients.txt")
+	identityFile := filepath.Join(dir, "identity.txt")
+	ioutil.WriteFile(recipientsFile, []byte("# bob\n"+bob.Recipient().String()+
+		"\n"), 0600)
+	ioutil.WriteFile(identityFile, []byte("# alice\n"+alice.String()+"\n"), 0600)
+	kmsProvider, err := newAgeKms(Defaults{
+		AgeRecipients:     []string{alice.Recipient().String()},
+		AgeRecipientsFile: recipientsFile,
+		AgeIdentityFile:   identityFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := kmsProvider.(AgeKms); len(a.Recipients) != 2 || len(a.Identities) != 1 {
+		t.Errorf("Got %d recipients and %d identities, want 2 and 1",
+			len(a.Recipients), len(a.Identities))
+	}
+	t.Setenv(ageIdentityEnvVar, bob.String())
+	kmsProvider, err = newAgeKms(Defaults{})
+	if err != nil || len(kmsProvider.(AgeKms).Identities) != 1 {
+		t.Errorf("Expected the identity from the environment (%v)", err)
+	}
+}
+
+func TestNewAgeKmsInvalid(t *testing.T) {
+	for _, opts := range []Defaults{
+		{AgeRecipients: []string{"age1notarecipient"}},
+		{AgeRecipientsFile: filepath.Join(t.TempDir(), "missing")},
+		{AgeIdentityFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if _, err := newAgeKms(opts); err == nil {
+			t.Errorf("Expected error for %+v", opts)
+		}
+	}
+}
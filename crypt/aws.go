@@ -15,39 +15,216 @@
 package crypt
 
 import (
+	"context"
+	"fmt"
+	"regexp"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
 )
 
+func init() {
+	mustRegisterProvider("AWS", newAwsKms)
+}
+
+//defaultAwsRegion is used when the region can't be resolved any other way, as
+//it was the only region supported before it became configurable
+const defaultAwsRegion = "eu-west-1"
+
+//EncryptionContext is a set of key/value pairs AWS KMS binds to the DEK, which
+//key policies can restrict with kms:EncryptionContext conditions, and which is
+//logged in CloudTrail
+type EncryptionContext map[string]string
+
+//UnmarshalFlag adds a key=value pair to the EncryptionContext, so the
+//--encryptionContext flag can be repeated
+func (e *EncryptionContext) UnmarshalFlag(value string) error {
+	return addKeyValue((*map[string]string)(e), "encryption context", value)
+}
+
 // AwsKms type
-type AwsKms struct{}
+type AwsKms struct {
+	//KeyName is the Key ID, Key ARN, Alias name or Alias ARN used when
+	//encrypting. It isn't needed when decrypting, as it's stored in the
+	//encrypted DEK.
+	KeyName string
+	//EncryptionContext is bound to the DEK when encrypting. It's stored in
+	//the ciphertext header, so isn't needed when decrypting ciphertexts.
+	EncryptionContext EncryptionContext
+	//Region of the key. If it's empty, the region is taken from the KeyName if
+	//it's an ARN, otherwise from AWS_REGION or the profile's config, falling
+	//back to eu-west-1.
+	Region string
+	//Profile is the shared config/credentials profile to use, if not the
+	//default (or AWS_PROFILE)
+	Profile string
+	//RoleArn is a role to assume with STS, using the profile's credentials
+	RoleArn string
+	//Endpoint overrides the KMS endpoint URL, e.g. for LocalStack or
+	//local-kms
+	Endpoint string
+}
+
+//newAwsKms creates an AwsKms from the input flags
+func newAwsKms(opts Defaults) (KmsProvider, error) {
+	return AwsKms{
+		KeyName:           opts.KeyName,
+		EncryptionContext: opts.EncryptionContext,
+		Region:            opts.AwsRegion,
+		Profile:           opts.AwsProfile,
+		RoleArn:           opts.AwsRoleArn,
+		Endpoint:          kmsEndpoint(opts.AwsKMSEndpoint, opts),
+	}, nil
+}
 
 func (a AwsKms) encryptedDekLength() int {
 	return 185
 }
 
-// uses aws kms to either encrypt or decrypt a byte slice
-func (a AwsKms) crypto(payload []byte, projectid, locationid, keyringid,
-	cryptokeyid, keyname string, encrypt bool) (resultText []byte, err error) {
+func (a AwsKms) keyID() string {
+	return a.KeyName
+}
+
+//awsRegionPattern matches region names, e.g. eu-west-1 or us-gov-west-1
+var awsRegionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
 
-	svc := kms.New(session.New(&aws.Config{
-		Region: aws.String("eu-west-1")}))
-	if encrypt {
-		resultText, err = awsKMSEncrypt(payload, keyname, svc)
-	} else {
-		resultText, err = awsKMSDecrypt(payload, svc)
+//checkKeyID checks a key ARN is for kms in a region, as the KMS endpoint's
+//host is resolved from the region. Key IDs and alias names are used in the
+//configured region.
+func (a AwsKms) checkKeyID() error {
+	keyArn, err := arn.Parse(a.KeyName)
+	if err != nil {
+		return nil
 	}
-	return
+	if keyArn.Service != "kms" || !awsRegionPattern.MatchString(keyArn.Region) {
+		return fmt.Errorf("aws key %q isn't a kms key ARN", a.KeyName)
+	}
+	return nil
+}
+
+//Name returns "AWS"
+func (a AwsKms) Name() string {
+	return "AWS"
+}
+
+//Encrypt uses aws kms to encrypt the DEK, bound to the EncryptionContext
+func (a AwsKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte,
+	err error) {
+	svc, err := a.client()
+	if err == nil {
+		encryptedDek, err = awsKMSEncrypt(ctx, dek, a.KeyName,
+			a.EncryptionContext, svc)
+	}
+	return encryptedDek, kmsError(a.Name(), true, err)
+}
+
+//Decrypt uses aws kms to decrypt a DEK bound to the EncryptionContext
+func (a AwsKms) Decrypt(ctx context.Context, encryptedDek []byte) ([]byte, error) {
+	return a.decryptWithContext(ctx, encryptedDek, a.EncryptionContext)
+}
+
+func (a AwsKms) encryptionContext() map[string]string {
+	return a.EncryptionContext
+}
+
+//decryptWithContext uses aws kms to decrypt a DEK bound to the encryption
+//context stored in the ciphertext header
+func (a AwsKms) decryptWithContext(ctx context.Context, encryptedDek []byte,
+	encryptionContext map[string]string) (dek []byte, err error) {
+	svc, err := a.client()
+	if err == nil {
+		dek, err = awsKMSDecrypt(ctx, encryptedDek, encryptionContext, svc)
+	}
+	return dek, kmsError(a.Name(), false, err)
+}
+
+//client returns a new aws kms client, in the key's region, using the
+//profile's credentials or the assumed role
+func (a AwsKms) client() (*kms.KMS, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: a.region()},
+		Profile:           a.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(defaultAwsRegion)
+	}
+	config := aws.NewConfig()
+	if a.RoleArn != "" {
+		config.Credentials = stscreds.NewCredentials(sess, a.RoleArn)
+	}
+	if a.Endpoint != "" {
+		config.Endpoint = aws.String(a.Endpoint)
+	}
+	return kms.New(sess, config), nil
+}
+
+//region returns the Region, or the region in the KeyName if it's an ARN, or
+//nil so the sdk resolves it from the environment and profile
+func (a AwsKms) region() *string {
+	if a.Region != "" {
+		return aws.String(a.Region)
+	}
+	if keyArn, err := arn.Parse(a.KeyName); err == nil && keyArn.Region != "" {
+		return aws.String(keyArn.Region)
+	}
+	return nil
+}
+
+//awsCiphertextBlobLength returns the length of what looks like an aws kms
+//ciphertext blob at the end of the legacy ciphertext, or zero if there isn't
+//one. Blobs are encryptedDekLength bytes, or occasionally one less, and start
+//01 01 02 00 or 01 02 02 00 (AQECA or AQICA in base64).
+func awsCiphertextBlobLength(cipherBytes []byte) int {
+	maxLength := AwsKms{}.encryptedDekLength()
+	for blobLength := maxLength; blobLength >= maxLength-1; blobLength-- {
+		if len(cipherBytes) >= blobLength+nonceLength &&
+			isAwsCiphertextBlob(cipherBytes[len(cipherBytes)-blobLength:]) {
+			return blobLength
+		}
+	}
+	return 0
+}
+
+//awsKeyArnPattern matches a KMS key ARN, including multi-region keys
+var awsKeyArnPattern = regexp.MustCompile(`arn:aws[a-z-]*:kms:[a-z]{2}(-[a-z]+)+-[0-9]+:` +
+	`[0-9]{12}:key/(mrk-[0-9a-f]{32}|[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12})`)
+
+//awsCiphertextBlobKeyID returns the ARN of the key recorded in the ciphertext
+//blob, or "" if one isn't found in it
+func awsCiphertextBlobKeyID(blob []byte) string {
+	return string(awsKeyArnPattern.Find(blob))
+}
+
+func isAwsCiphertextBlob(blob []byte) bool {
+	return blob[0] == 1 && (blob[1] == 1 || blob[1] == 2) && blob[2] == 2 &&
+		blob[3] == 0
+}
+
+//awsEncryptionContext converts an encryption context for the aws sdk, or
+//returns nil if it's empty
+func awsEncryptionContext(encryptionContext map[string]string) map[string]*string {
+	if len(encryptionContext) == 0 {
+		return nil
+	}
+	return aws.StringMap(encryptionContext)
 }
 
 //awsKMSEncrypt uses aws kms to encypt a bite slice
-func awsKMSEncrypt(payload []byte, keyname string, svc *kms.KMS) (resultText []byte, err error) {
+func awsKMSEncrypt(ctx context.Context, payload []byte, keyname string,
+	encryptionContext map[string]string, svc *kms.KMS) (resultText []byte, err error) {
 	input := &kms.EncryptInput{
-		KeyId:     aws.String(keyname),
-		Plaintext: payload,
+		KeyId:             aws.String(keyname),
+		Plaintext:         payload,
+		EncryptionContext: awsEncryptionContext(encryptionContext),
 	}
-	result, err := svc.Encrypt(input)
+	result, err := svc.EncryptWithContext(ctx, input)
 	if err == nil {
 		resultText = result.CiphertextBlob
 	}
@@ -55,11 +232,13 @@ func awsKMSEncrypt(payload []byte, keyname string, svc *kms.KMS) (resultText []b
 }
 
 //awsKMSDecrypt uses aws kms to decypt a bite slice
-func awsKMSDecrypt(payload []byte, svc *kms.KMS) (resultText []byte, err error) {
+func awsKMSDecrypt(ctx context.Context, payload []byte,
+	encryptionContext map[string]string, svc *kms.KMS) (resultText []byte, err error) {
 	input := &kms.DecryptInput{
-		CiphertextBlob: payload,
+		CiphertextBlob:    payload,
+		EncryptionContext: awsEncryptionContext(encryptionContext),
 	}
-	result, err := svc.Decrypt(input)
+	result, err := svc.DecryptWithContext(ctx, input)
 	if err == nil {
 		resultText = result.Plaintext
 	}
@@ -0,0 +1,84 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const awsTestConfig = `[profile sydney]
+region = ap-southeast-2
+`
+
+var awsRegionTests = []struct {
+	name   string
+	kms    AwsKms
+	env    string
+	region string
+}{
+	{"default", AwsKms{KeyName: "alias/my-key"}, "", defaultAwsRegion},
+	{"environment", AwsKms{KeyName: "alias/my-key"}, "us-west-2", "us-west-2"},
+	{"profile", AwsKms{Profile: "sydney"}, "", "ap-southeast-2"},
+	{"key arn", AwsKms{KeyName: "arn:aws:kms:us-east-1:111122223333:key/1234"},
+		"us-west-2", "us-east-1"},
+	{"flag", AwsKms{KeyName: "arn:aws:kms:us-east-1:111122223333:key/1234",
+		Region: "eu-central-1"}, "us-west-2", "eu-central-1"},
+}
+
+func TestAwsRegion(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := ioutil.WriteFile(configFile, []byte(awsTestConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_PROFILE", "")
+	for _, test := range awsRegionTests {
+		t.Setenv("AWS_REGION", test.env)
+		svc, err := test.kms.client()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if region := aws.StringValue(svc.Config.Region); region != test.region {
+			t.Errorf("%s: got region %s, want %s", test.name, region, test.region)
+		}
+	}
+}
+
+func TestAwsRoleArn(t *testing.T) {
+	a := AwsKms{Region: "eu-west-2"}
+	svc, _ := a.client()
+	a.RoleArn = "arn:aws:iam::111122223333:role/mantle"
+	roleSvc, err := a.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roleSvc.Config.Credentials == svc.Config.Credentials {
+		t.Error("Expected assumed role credentials")
+	}
+}
+
+func TestAwsEndpoint(t *testing.T) {
+	svc, err := AwsKms{Region: "eu-west-2", Endpoint: "http://localhost:8099"}.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Endpoint != "http://localhost:8099" {
+		t.Errorf("Got endpoint %s, want http://localhost:8099", svc.Endpoint)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func init() {
+	mustRegisterProvider("AZURE", newAzureKms)
+}
+
+const (
+	azureAPIVersion           = "7.4"
+	azureWrapAlgorithm        = "RSA-OAEP-256"
+	azureVaultScope           = "https://vault.azure.net"
+	azureVaultHostSuffix      = ".vault.azure.net"
+	defaultAzureAuthorityHost = "https://login.microsoftonline.com"
+)
+
+//azureIMDSEndpoint is the instance metadata service's managed identity token
+//endpoint
+var azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+//AzureKms uses an Azure Key Vault RSA key to wrap DEKs with RSA-OAEP-256. The
+//encrypted DEK records the key version used, so it's unwrapped with the same
+//version after the key is rotated.
+type AzureKms struct {
+	//KeyName is the key identifier, i.e.
+	//https://<vault>.vault.azure.net/keys/<name>[/<version>], wrapping with
+	//the latest version if the version is omitted
+	KeyName string
+	//Endpoint overrides the vault URL in the KeyName, e.g. for a local
+	//stand-in or a private endpoint. Requests to plain http endpoints aren't
+	//authenticated, and access tokens are only sent to the Endpoint or
+	//*.vault.azure.net.
+	Endpoint string
+	//TenantID, ClientID and ClientSecret are the service principal
+	//credentials. Without a ClientSecret, the managed identity is used, with
+	//the ClientID selecting a user-assigned identity.
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	//AuthorityHost is the Azure AD host, login.microsoftonline.com by default
+	AuthorityHost string
+}
+
+//azureKey identifies a Key Vault key
+type azureKey struct {
+	vaultURL, name, version string
+}
+
+//newAzureKms creates an AzureKms from the input flags, and the
+//MANTLE_AZURE_KMS_ENDPOINT, AZURE_TENANT_ID, AZURE_CLIENT_ID,
+//AZURE_CLIENT_SECRET and AZURE_AUTHORITY_HOST environment variables
+func newAzureKms(opts Defaults) (KmsProvider, error) {
+	if _, err := parseAzureKeyID(opts.KeyName); err != nil {
+		return nil, err
+	}
+	return AzureKms{
+		KeyName:       opts.KeyName,
+		Endpoint:      os.Getenv("MANTLE_AZURE_KMS_ENDPOINT"),
+		TenantID:      os.Getenv("AZURE_TENANT_ID"),
+		ClientID:      os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret:  os.Getenv("AZURE_CLIENT_SECRET"),
+		AuthorityHost: os.Getenv("AZURE_AUTHORITY_HOST"),
+	}, nil
+}
+
+//parseAzureKeyID parses a key identifier, with or without a version
+func parseAzureKeyID(id string) (key azureKey, err error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || !validAzureKeyPath(path) {
+		return key, fmt.Errorf("azure key %q isn't of the form "+
+			"https://<vault>.vault.azure.net/keys/<name>[/<version>]", id)
+	}
+	key = azureKey{vaultURL: u.Scheme + "://" + u.Host, name: path[1]}
+	if len(path) == 3 {
+		key.version = path[2]
+	}
+	return
+}
+
+//validAzureKeyPath reports whether the path is keys/<name>[/<version>]
+func validAzureKeyPath(path []string) bool {
+	return len(path) >= 2 && len(path) <= 3 && path[0] == "keys"
+}
+
+func (a AzureKms) keyID() string {
+	return a.KeyName
+}
+
+//checkKeyID checks the key is in an Azure vault, unless the Endpoint's set, as
+//requests are sent to it instead
+func (a AzureKms) checkKeyID() error {
+	key, err := parseAzureKeyID(a.KeyName)
+	if err != nil || a.Endpoint != "" {
+		return err
+	}
+	if vaultURL, _ := url.Parse(key.vaultURL); !isAzureVault(vaultURL) {
+		return fmt.Errorf("azure key %q isn't in a https://<vault>%s vault",
+			a.KeyName, azureVaultHostSuffix)
+	}
+	return nil
+}
+
+//isAzureVault reports whether the URL is of an Azure Key Vault
+func isAzureVault(u *url.URL) bool {
+	return u.Scheme == "https" &&
+		strings.HasSuffix(strings.ToLower(u.Hostname()), azureVaultHostSuffix)
+}
+
+//Name returns "AZURE"
+func (a AzureKms) Name() string {
+	return "AZURE"
+}
+
+//Encrypt wraps the DEK with the key, returning the identifier of the key
+//version used followed by the wrapped DEK
+func (a AzureKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte, err error) {
+	key, err := parseAzureKeyID(a.KeyName)
+	if err == nil {
+		var resp azureKeyOperation
+		if resp, err = a.keyOperation(ctx, key, "wrapkey", dek); err == nil {
+			encryptedDek = encodeAzureDek(resp.Kid, resp.Value)
+		}
+	}
+	return encryptedDek, kmsError(a.Name(), true, err)
+}
+
+//Decrypt unwraps the DEK with the key version it was wrapped with
+func (a AzureKms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte, err error) {
+	key, wrapped, err := a.decodeAzureDek(encryptedDek)
+	if err == nil {
+		var resp azureKeyOperation
+		if resp, err = a.keyOperation(ctx, key, "unwrapkey", wrapped); err == nil {
+			dek = resp.Value
+		}
+	}
+	return dek, kmsError(a.Name(), false, err)
+}
+
+//encodeAzureDek encodes the wrapped DEK as kidLength[2]kid wrappedDek
+func encodeAzureDek(kid string, wrapped []byte) []byte {
+	encoded := make([]byte, 2, 2+len(kid)+len(wrapped))
+	binary.BigEndian.PutUint16(encoded, uint16(len(kid)))
+	return append(append(encoded, kid...), wrapped...)
+}
+
+//decodeAzureDek decodes an encrypted DEK, checking it was wrapped with the
+//configured key, as the key version is taken from it
+func (a AzureKms) decodeAzureDek(encryptedDek []byte) (azureKey, []byte, error) {
+	if len(encryptedDek) < 2 ||
+		len(encryptedDek) < 2+int(binary.BigEndian.Uint16(encryptedDek)) {
+		return azureKey{}, nil, errors.New("encrypted DEK is too short")
+	}
+	kidLength := 2 + int(binary.BigEndian.Uint16(encryptedDek))
+	kid, err := parseAzureKeyID(string(encryptedDek[2:kidLength]))
+	if err != nil {
+		return kid, nil, err
+	}
+	key, err := parseAzureKeyID(a.KeyName)
+	if err == nil && !key.sameKey(kid) {
+		err = fmt.Errorf("DEK was wrapped with key %s/keys/%s, not %s", kid.vaultURL,
+			kid.name, a.KeyName)
+	}
+	key.version = kid.version
+	return key, encryptedDek[kidLength:], err
+}
+
+//sameKey reports whether the keys are the same, ignoring their versions
+func (k azureKey) sameKey(other azureKey) bool {
+	return k.name == other.name && strings.EqualFold(k.vaultURL, other.vaultURL)
+}
+
+//azureKeyOperation is the request and response of wrapkey and unwrapkey
+type azureKeyOperation struct {
+	Alg   string     `json:"alg,omitempty"`
+	Kid   string     `json:"kid,omitempty"`
+	Value azureBytes `json:"value"`
+}
+
+//azureBytes are encoded as unpadded base64url in Key Vault's JSON
+type azureBytes []byte
+
+//MarshalJSON encodes the bytes as unpadded base64url
+func (b azureBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+//UnmarshalJSON decodes base64url, with or without padding
+func (b *azureBytes) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err == nil {
+		*b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	return
+}
+
+//keyOperation wraps or unwraps the value with the key
+func (a AzureKms) keyOperation(ctx context.Context, key azureKey, operation string,
+	value []byte) (resp azureKeyOperation, err error) {
+	body, _ := json.Marshal(azureKeyOperation{Alg: azureWrapAlgorithm, Value: value})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		a.keyURL(key, operation), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err = a.authorize(ctx, req); err == nil {
+		err = azureDo(req, &resp)
+	}
+	return
+}
+
+//keyURL returns the URL of the key operation, using the Endpoint in place of
+//the vault URL if it's set
+func (a AzureKms) keyURL(key azureKey, operation string) string {
+	vaultURL := key.vaultURL
+	if a.Endpoint != "" {
+		vaultURL = strings.TrimSuffix(a.Endpoint, "/")
+	}
+	path := []string{vaultURL, "keys", url.PathEscape(key.name)}
+	if key.version != "" {
+		path = append(path, url.PathEscape(key.version))
+	}
+	return strings.Join(append(path, operation), "/") + "?api-version=" +
+		azureAPIVersion
+}
+
+//authorize adds an access token to requests, unless they're to a plain http
+//endpoint. The token's only sent to Azure vaults, or the Endpoint.
+func (a AzureKms) authorize(ctx context.Context, req *http.Request) error {
+	if req.URL.Scheme == "http" {
+		return nil
+	}
+	if a.Endpoint == "" && !isAzureVault(req.URL) {
+		return fmt.Errorf("not sending an access token to %s, as it isn't an "+
+			"Azure vault, set the KMS endpoint to use it", req.URL.Host)
+	}
+	token, err := a.token(ctx)
+	if err == nil {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return err
+}
+
+//token returns an access token for Key Vault, using the service principal if
+//there's a ClientSecret, or the managed identity otherwise
+func (a AzureKms) token(ctx context.Context) (string, error) {
+	var req *http.Request
+	var err error
+	if a.ClientSecret != "" {
+		req, err = a.servicePrincipalTokenRequest(ctx)
+	} else {
+		req, err = a.managedIdentityTokenRequest(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = azureDo(req, &resp)
+	return resp.AccessToken, err
+}
+
+//servicePrincipalTokenRequest requests a token with the client credentials
+func (a AzureKms) servicePrincipalTokenRequest(ctx context.Context) (*http.Request, error) {
+	authorityHost := a.AuthorityHost
+	if authorityHost == "" {
+		authorityHost = defaultAzureAuthorityHost
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {a.ClientID},
+		"client_secret": {a.ClientSecret},
+		"scope":         {azureVaultScope + "/.default"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"),
+			url.PathEscape(a.TenantID)), strings.NewReader(form.Encode()))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return req, err
+}
+
+//managedIdentityTokenRequest requests a token from the instance metadata
+//service
+func (a AzureKms) managedIdentityTokenRequest(ctx context.Context) (*http.Request, error) {
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureVaultScope}}
+	if a.ClientID != "" {
+		query.Set("client_id", a.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		azureIMDSEndpoint+"?"+query.Encode(), nil)
+	if err == nil {
+		req.Header.Set("Metadata", "true")
+	}
+	return req, err
+}
+
+//azureDo sends the request, decoding the JSON response
+func azureDo(req *http.Request, resp interface{}) error {
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(httpResp.Body, 1024))
+		return fmt.Errorf("azure responded %s: %s", httpResp.Status,
+			strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
@@ -0,0 +1,190 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//fakeKeyVault wraps and unwraps with RSA-OAEP-256, using a new RSA key for
+//each version of the key "mantle"
+type fakeKeyVault struct {
+	url      string
+	versions []*rsa.PrivateKey
+}
+
+func newFakeKeyVault(t *testing.T) *fakeKeyVault {
+	f := &fakeKeyVault{}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	f.rotate(t)
+	return f
+}
+
+//rotate adds a new version of the key
+func (f *fakeKeyVault) rotate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.versions = append(f.versions, key)
+}
+
+func (f *fakeKeyVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req azureKeyOperation
+	json.NewDecoder(r.Body).Decode(&req)
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	version := f.version(path)
+	if req.Alg != azureWrapAlgorithm || path[1] != "mantle" || version == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp, err := f.keyOperation(path[len(path)-1], f.versions[version-1], req.Value)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp.Kid = fmt.Sprintf("%s/keys/mantle/v%d", f.url, version)
+	json.NewEncoder(w).Encode(resp)
+}
+
+//version returns the key version in the path keys/mantle[/v<N>]/operation,
+//the latest if it's omitted, or zero if there's no such version
+func (f *fakeKeyVault) version(path []string) (version int) {
+	version = len(f.versions)
+	if len(path) == 4 {
+		fmt.Sscanf(path[2], "v%d", &version)
+	}
+	if version < 1 || version > len(f.versions) {
+		return 0
+	}
+	return
+}
+
+func (f *fakeKeyVault) keyOperation(operation string, key *rsa.PrivateKey,
+	value []byte) (resp azureKeyOperation, err error) {
+	if operation == "wrapkey" {
+		resp.Value, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey,
+			value, nil)
+	} else {
+		resp.Value, err = rsa.DecryptOAEP(sha256.New(), nil, key, value, nil)
+	}
+	return
+}
+
+func TestAzureRotation(t *testing.T) {
+	fake := newFakeKeyVault(t)
+	a := AzureKms{KeyName: fake.url + "/keys/mantle"}
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the DEK is still unwrapped with the version it was wrapped with
+	fake.rotate(t)
+	result, err := PlainTextFromPrimitives(sealed, a, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Got %s (%v), want %s", result, err, plaintext)
+	}
+	other := AzureKms{KeyName: fake.url + "/keys/other"}
+	if _, err = PlainTextFromPrimitives(sealed, other, nil); !errors.Is(err,
+		ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v", err, ErrKMSUnavailable)
+	}
+}
+
+func TestAzureEndpoint(t *testing.T) {
+	fake := newFakeKeyVault(t)
+	a := AzureKms{KeyName: "https://myvault.vault.azure.net/keys/mantle/v1",
+		Endpoint: fake.url}
+	if _, err := a.Encrypt(context.Background(), []byte("dek")); err != nil {
+		t.Error(err)
+	}
+}
+
+var azureKeyIDTests = []struct {
+	id    string
+	key   azureKey
+	valid bool
+}{
+	{"https://v.vault.azure.net/keys/k", azureKey{"https://v.vault.azure.net", "k", ""}, true},
+	{"https://v.vault.azure.net/keys/k/1a2b", azureKey{"https://v.vault.azure.net", "k", "1a2b"}, true},
+	{"https://v.vault.azure.net/secrets/k", azureKey{}, false},
+	{"https://v.vault.azure.net/keys", azureKey{}, false},
+	{"mantle", azureKey{}, false},
+}
+
+func TestParseAzureKeyID(t *testing.T) {
+	for _, test := range azureKeyIDTests {
+		key, err := parseAzureKeyID(test.id)
+		if (err == nil) != test.valid || (test.valid && key != test.key) {
+			t.Errorf("Parsing %s got %+v (%v)", test.id, key, err)
+		}
+	}
+}
+
+//azureTokenServer returns a server issuing the token for requests with the
+//form or query values
+func azureTokenServer(t *testing.T, values map[string]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		r.ParseForm()
+		for key, value := range values {
+			if r.Form.Get(key) != value {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token"})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestAzureAuthorize(t *testing.T) {
+	defer func(endpoint string) { azureIMDSEndpoint = endpoint }(azureIMDSEndpoint)
+	azureIMDSEndpoint = azureTokenServer(t, map[string]string{"client_id": "identity"})
+	servicePrincipal := AzureKms{TenantID: "tenant", ClientID: "client",
+		ClientSecret: "secret", AuthorityHost: azureTokenServer(t,
+			map[string]string{"client_id": "client", "client_secret": "secret"})}
+	for _, a := range []AzureKms{servicePrincipal, {ClientID: "identity"}} {
+		req, _ := http.NewRequest(http.MethodPost, "https://v.vault.azure.net", nil)
+		if err := a.authorize(context.Background(), req); err != nil ||
+			req.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorizing with %+v failed (%v)", a, err)
+		}
+	}
+}
+
+func TestAzureAuthorizeUntrustedHost(t *testing.T) {
+	a := AzureKms{ClientID: "identity"}
+	req, _ := http.NewRequest(http.MethodPost, "https://evil.example.com", nil)
+	if err := a.authorize(context.Background(), req); err == nil ||
+		req.Header.Get("Authorization") != "" {
+		t.Errorf("Expected no token to be sent to %s", req.URL.Host)
+	}
+}
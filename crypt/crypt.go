@@ -18,62 +18,100 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"strings"
+	"path/filepath"
 
 	flags "github.com/jessevdk/go-flags"
 )
 
-// KmsProvider type
-type KmsProvider interface {
-	crypto(payload []byte, projectid, locationid, keyringid,
-		cryptokeyid, keyname string, encrypt bool) (resultText []byte, err error)
-	encryptedDekLength() int
-}
-
 //Defaults type defining input flags
 type Defaults struct {
-	CryptoKeyID string `short:"c" long:"cryptokeyId" description:"Google KMS crytoKeyId" required:"false"`
-	KeyRingID   string `short:"k" long:"keyringId" description:"Google KMS keyRingId" required:"false"`
-	KeyName     string `short:"n" long:"keyName" description:"Google KMS keyName or AWS KMS keyId" required:"false"`
-	LocationID  string `short:"l" long:"locationId" description:"Google KMS locationId" required:"false"`
-	ProjectID   string `short:"p" long:"projectId" description:"Google projectId" required:"false"`
-	KMSProvider string `short:"m" long:"kmsProvider" description:"KMS provider" required:"false"`
+	CryptoKeyID         string            `short:"c" long:"cryptokeyId" description:"Google KMS crytoKeyId" required:"false"`
+	KeyRingID           string            `short:"k" long:"keyringId" description:"Google KMS keyRingId" required:"false"`
+	KeyName             string            `short:"n" long:"keyName" description:"Google KMS keyName or AWS KMS keyId" required:"false"`
+	LocationID          string            `short:"l" long:"locationId" description:"Google KMS locationId" required:"false"`
+	ProjectID           string            `short:"p" long:"projectId" description:"Google projectId" required:"false"`
+	KMSProvider         string            `short:"m" long:"kmsProvider" description:"KMS provider, e.g. AGE, AWS, AZURE, GCP, LOCAL, PKCS11 or VAULT (default: GCP)" required:"false"`
+	Keys                []KeyRecipient    `long:"key" description:"KMS key to wrap the DEK with as provider:keyName, instead of -m and -n, repeat to wrap it with several keys, any of which can decrypt" required:"false"`
+	Threshold           int               `long:"threshold" description:"Number of the --key keys needed to decrypt, splitting the DEK into a share for each key (default: any one key)" required:"false"`
+	KMSEndpoint         string            `long:"kmsEndpoint" env:"MANTLE_KMS_ENDPOINT" description:"AWS and GCP KMS service endpoint URL, e.g. for a local stand-in, unless --awsKmsEndpoint or --gcpKmsEndpoint is given" required:"false"`
+	AwsKMSEndpoint      string            `long:"awsKmsEndpoint" env:"MANTLE_AWS_KMS_ENDPOINT" description:"AWS KMS service endpoint URL" required:"false"`
+	GcpKMSEndpoint      string            `long:"gcpKmsEndpoint" env:"MANTLE_GCP_KMS_ENDPOINT" description:"Google KMS service endpoint URL" required:"false"`
+	AAD                 AAD               `long:"aad" description:"Additional authenticated data as key=value, repeat for more keys, the same AAD is needed to decrypt" required:"false"`
+	Format              string            `long:"format" description:"Encrypt only the values of a structured file, in the format dotenv, json or yaml, leaving its keys readable" required:"false"`
+	KeyRegex            string            `long:"keyRegex" description:"With --format, only encrypt values under keys matching the regex, e.g. password|secret|token" required:"false"`
+	AwsRegion           string            `long:"awsRegion" description:"AWS KMS region, if not in the keyName ARN, AWS_REGION or the profile" required:"false"`
+	AwsProfile          string            `long:"awsProfile" description:"AWS shared config profile" required:"false"`
+	AwsRoleArn          string            `long:"awsRoleArn" description:"AWS IAM role to assume with STS" required:"false"`
+	VaultNamespace      string            `long:"vaultNamespace" env:"VAULT_NAMESPACE" description:"Vault Enterprise namespace" required:"false"`
+	VaultTransitMount   string            `long:"vaultTransitMount" description:"Path the Vault Transit secrets engine is mounted at (default: transit)" required:"false"`
+	VaultRoleID         string            `long:"vaultRoleId" env:"VAULT_ROLE_ID" description:"Vault AppRole role_id to log in with, the secret_id is read from VAULT_SECRET_ID" required:"false"`
+	VaultKubernetesRole string            `long:"vaultKubernetesRole" env:"VAULT_KUBERNETES_ROLE" description:"Vault role to log in as with Kubernetes auth, using the pod's service account token" required:"false"`
+	AgeRecipients       []string          `long:"ageRecipient" description:"age recipient (age1... public key) to encrypt to, repeat for more recipients" required:"false"`
+	AgeRecipientsFile   string            `long:"ageRecipientsFile" env:"MANTLE_AGE_RECIPIENTS_FILE" description:"Path of file of age recipients to encrypt to, one per line" required:"false"`
+	AgeIdentityFile     string            `long:"ageIdentityFile" env:"MANTLE_AGE_IDENTITY_FILE" description:"Path of age identity file to decrypt with" required:"false"`
+	Pkcs11Module        string            `long:"pkcs11Module" env:"MANTLE_PKCS11_MODULE" description:"Path of the PKCS#11 module, the key label is given in -n,--keyName" required:"false"`
+	Pkcs11Slot          uint              `long:"pkcs11Slot" env:"MANTLE_PKCS11_SLOT" description:"ID of the PKCS#11 slot holding the token (default: 0)" required:"false"`
+	Pkcs11Pin           string            `long:"pkcs11Pin" env:"MANTLE_PKCS11_PIN" description:"PKCS#11 user PIN" required:"false"`
+	LocalKeyFile        string            `long:"localKeyFile" env:"MANTLE_LOCAL_KEY_FILE" description:"Path of the LOCAL KMS provider's key, created by keygen" required:"false"`
+	EncryptionContext   EncryptionContext `long:"encryptionContext" description:"AWS KMS encryption context as key=value, repeat for more keys, it's stored in the ciphertext and replayed when decrypting" required:"false"`
 }
 
 var (
 	defaultOptions = Defaults{}
 	//Parser is a new Parser with default options
-	Parser       = flags.NewParser(&defaultOptions, flags.Default)
-	kmsProviders = map[string]KmsProvider{
-		"AWS": AwsKms{},
-		"GCP": GcpKms{},
-	}
+	Parser = flags.NewParser(&defaultOptions, flags.Default)
 )
 
 const (
 	nonceLength = 12
 	dekLength   = 32
+	//stdioFilepath is the filepath used to read from stdin, or write to stdout
+	stdioFilepath = "-"
 )
 
+//statusOutput is where progress messages are written, it's switched to stderr
+//when stdout is used for the output itself
+var statusOutput io.Writer = os.Stdout
+
+//getKmsProvider creates the named KmsProvider using 'defaultOptions' go-flags,
+//or a provider for the keys given in the --key flags
 func getKmsProvider(provider string) (kmsProvider KmsProvider, err error) {
-	if provider == "" {
-		return GcpKms{}, nil
+	if len(defaultOptions.Keys) == 0 {
+		return NewKmsProvider(provider, defaultOptions)
 	}
-	kmsProvider, ok := kmsProviders[strings.ToUpper(provider)]
-	if !ok {
-		err = fmt.Errorf("KMS Provider %v not supported", provider)
+	if provider != "" {
+		return nil, errors.New("-m,--kmsProvider can't be used with --key, " +
+			"which gives the provider of each key")
 	}
-	return
+	if defaultOptions.Threshold > 0 {
+		return NewThresholdKms(defaultOptions.Keys, defaultOptions.Threshold,
+			defaultOptions)
+	}
+	return NewMultiKms(defaultOptions.Keys, defaultOptions)
+}
+
+//getDecryptKmsProvider creates the KmsProvider to decrypt with, as
+//getKmsProvider does, except that without the -m or --key flags, a DetectedKms
+//uses the provider and key recorded in the ciphertext
+func getDecryptKmsProvider() (KmsProvider, error) {
+	if defaultOptions.KMSProvider == "" && len(defaultOptions.Keys) == 0 {
+		return DetectedKms{Opts: defaultOptions}, nil
+	}
+	return getKmsProvider(defaultOptions.KMSProvider)
 }
 
-//check panics if error is not nil
-func check(e error) {
-	if e != nil {
-		panic(e.Error())
+//kmsEndpoint returns the provider's own KMS endpoint if it's given, or the
+//--kmsEndpoint otherwise
+func kmsEndpoint(endpoint string, opts Defaults) string {
+	if endpoint != "" {
+		return endpoint
 	}
+	return opts.KMSEndpoint
 }
 
 //byteSliceToString converts a byte slice to a string, and returns it
@@ -83,71 +121,168 @@ func byteSliceToString(dat []byte) (resultString string) {
 }
 
 //randByteSlice creates and returns a random byte slice, of desired size
-func randByteSlice(size int) (bytes []byte) {
+func randByteSlice(size int) (bytes []byte, err error) {
 	bytes = make([]byte, size)
-	_, err := io.ReadFull(rand.Reader, bytes)
-	check(err)
+	_, err = io.ReadFull(rand.Reader, bytes)
 	return
 }
 
 //secureDelete zerofills the desired file, and removes it
 func secureDelete(filepath string, stdOut bool) (err error) {
-	zerofill(filepath, stdOut)
-	deleteFile(filepath)
-	return
+	if err = zerofill(filepath, stdOut); err != nil {
+		return
+	}
+	return deleteFile(filepath)
 }
 
 //zerofill zerofills the desired file
 func zerofill(filepath string, stdOut bool) (err error) {
 	fi, err := os.Stat(filepath)
-	check(err)
+	if err != nil {
+		return
+	}
 	switch mode := fi.Mode(); {
 	case mode.IsDir():
 		if !stdOut {
-			fmt.Printf("%s\n", "Didn't zerofill/delete unencrypted file \""+
+			fmt.Fprintf(statusOutput, "%s\n", "Didn't zerofill/delete unencrypted file \""+
 				filepath+"\" as it's not a file")
 		}
 	case mode.IsRegular():
-		file, err := os.OpenFile(filepath, os.O_RDWR, 0666)
-		check(err)
-		defer file.Close()
-		fileInfo, err := file.Stat()
-		check(err)
-		zeroBytes := make([]byte, fileInfo.Size())
-		n, err := file.Write(zeroBytes)
-		check(err)
-		if !stdOut {
-			fmt.Printf("Wiped %v bytes from %s.\n", n, filepath)
-		}
+		err = zerofillRegular(filepath, stdOut)
+	}
+	return
+}
+
+//zerofillRegular overwrites every byte of a regular file with zeros
+func zerofillRegular(filepath string, stdOut bool) (err error) {
+	file, err := os.OpenFile(filepath, os.O_RDWR, 0666)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return
+	}
+	zeroBytes := make([]byte, fileInfo.Size())
+	n, err := file.Write(zeroBytes)
+	if err == nil && !stdOut {
+		fmt.Fprintf(statusOutput, "Wiped %v bytes from %s.\n", n, filepath)
 	}
 	return
 }
 
 //delete file removes the file
 func deleteFile(filepath string) (err error) {
-	err = os.Remove(filepath)
-	check(err)
+	return os.Remove(filepath)
+}
+
+//openInput opens the file to read from, or stdin for "-"
+func openInput(filepath string) (io.ReadCloser, error) {
+	if filepath == stdioFilepath {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(filepath)
+}
+
+//readInput reads all of the file, or stdin for "-"
+func readInput(filepath string) ([]byte, error) {
+	input, err := openInput(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	return ioutil.ReadAll(input)
+}
+
+//writeOutput writes the data to stdout for "-", or else atomically replaces
+//the file with it, keeping its permissions if it already exists
+func writeOutput(filepath string, data []byte) error {
+	write := func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+	if filepath == stdioFilepath {
+		return write(os.Stdout)
+	}
+	return writeFileAtomic(filepath, fileMode(filepath, 0644), write)
+}
+
+//sameFilepath reports whether two filepaths (other than stdin/stdout) refer
+//to the same file
+func sameFilepath(a, b string) bool {
+	return a != stdioFilepath && filepath.Clean(a) == filepath.Clean(b)
+}
+
+//writeFileAtomic writes to a temporary file in the same directory as the
+//named file, syncs it to disk, then renames it over the named file. Readers
+//see either the old or new contents, never a partially written file.
+func writeFileAtomic(name string, perm os.FileMode,
+	write func(w io.Writer) error) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return
+	}
+	if err = write(tmp); err == nil {
+		err = syncAndClose(tmp, perm)
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
 	return
 }
 
-//cipherblock creates and returns a new aes cipher.Block
-func cipherblock(dek []byte) (cipherblock cipher.Block) {
-	cipherblock, err := aes.NewCipher(dek)
-	check(err)
+//syncAndClose sets the file's permissions, syncs it to disk, and closes it
+func syncAndClose(file *os.File, perm os.FileMode) (err error) {
+	if err = file.Chmod(perm); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	return
 }
 
-//cipherText seals or opens the text
-func cipherText(text []byte, cipherblock cipher.Block, nonce []byte,
-	seal bool) (ciphertext []byte) {
-	aesgcm, err := cipher.NewGCM(cipherblock)
-	check(err)
-	var errm error
+//fileMode returns the permissions of the named file, or the default if it
+//doesn't exist
+func fileMode(name string, defaultMode os.FileMode) os.FileMode {
+	if fileInfo, err := os.Stat(name); err == nil {
+		return fileInfo.Mode().Perm()
+	}
+	return defaultMode
+}
+
+//newGCM returns AES-GCM using the DEK
+func newGCM(dek []byte) (cipher.AEAD, error) {
+	cipherblock, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(cipherblock)
+}
+
+//cipherText seals or opens the text, authenticating the additional data
+//alongside it
+func cipherText(text []byte, dek, nonce, additionalData []byte,
+	seal bool) (ciphertext []byte, err error) {
+	aesgcm, err := newGCM(dek)
+	if err != nil {
+		return
+	}
+	if len(nonce) != aesgcm.NonceSize() {
+		return nil, fmt.Errorf("%w: nonce must be %d bytes", ErrInvalidCiphertext,
+			aesgcm.NonceSize())
+	}
 	if seal {
-		ciphertext = aesgcm.Seal(nil, nonce, text, nil)
-	} else {
-		ciphertext, errm = aesgcm.Open(nil, nonce, text, nil)
+		return aesgcm.Seal(nil, nonce, text, additionalData), nil
+	}
+	if ciphertext, err = aesgcm.Open(nil, nonce, text, additionalData); err != nil {
+		err = ErrAuthenticationFailed
 	}
-	check(errm)
 	return
 }
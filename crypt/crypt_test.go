@@ -16,8 +16,11 @@ package crypt
 
 import (
 	"encoding/base64"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -28,13 +31,19 @@ func TestZerofill(t *testing.T) {
 
 	d1 := []byte("hello\ngo\n")
 	err := ioutil.WriteFile(path, d1, 0644)
-	check(err)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	er := zerofill(path, false)
-	check(er)
+	if er != nil {
+		t.Fatal(er)
+	}
 
 	dat, err := ioutil.ReadFile(path)
-	check(err)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	zerod := make([]byte, len(d1))
 	if !reflect.DeepEqual(zerod, dat) {
@@ -47,10 +56,14 @@ func TestDeleteFile(t *testing.T) {
 
 	d1 := []byte("hello\ngo\n")
 	err := ioutil.WriteFile(path, d1, 0644)
-	check(err)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	er := deleteFile(path)
-	check(er)
+	if er != nil {
+		t.Fatal(er)
+	}
 
 	if _, err := os.Stat(path); err == nil {
 		t.Error("file still exists")
@@ -62,10 +75,14 @@ func TestSecureDelete(t *testing.T) {
 
 	d1 := []byte("hello\ngo\n")
 	err := ioutil.WriteFile(path, d1, 0644)
-	check(err)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	er := secureDelete(path, false)
-	check(er)
+	if er != nil {
+		t.Fatal(er)
+	}
 
 	if _, err := os.Stat(path); err == nil {
 		t.Error("file still exists")
@@ -81,8 +98,12 @@ func TestPlainText(t *testing.T) {
 
 	err := ioutil.WriteFile(path, []byte(s1), 0644)
 
-	check(err)
-	PlainText(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = PlainText(path); err == nil {
+		t.Error("Expected error decrypting zero'ed ciphertext")
+	}
 }
 
 var providerTests = []struct {
@@ -110,3 +131,30 @@ func TestGetKmsProvider(t *testing.T) {
 	}
 
 }
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mantle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cipher.txt")
+	write := func(content string, writeErr error) error {
+		return writeFileAtomic(path, 0600, func(w io.Writer) error {
+			w.Write([]byte(content))
+			return writeErr
+		})
+	}
+	if err = write("old", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = write("new", errors.New("failed")); err == nil {
+		t.Error("Expected write error to be returned")
+	}
+	dat, _ := ioutil.ReadFile(path)
+	files, _ := ioutil.ReadDir(dir)
+	if string(dat) != "old" || len(files) != 1 {
+		t.Errorf("Failed write should leave only the original file, got %s, %d files",
+			dat, len(files))
+	}
+}
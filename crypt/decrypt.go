@@ -17,11 +17,14 @@ package crypt
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"strconv"
+	"strings"
 )
 
 func init() {
@@ -34,7 +37,7 @@ func init() {
 
 //DecryptCommand type
 type DecryptCommand struct {
-	Filepath         string `short:"f" long:"filepath" description:"Path of file to get encrypted string from" default:"./cipher.txt"`
+	Filepath         string `short:"f" long:"filepath" description:"Path of file to get encrypted string from, or - for stdin" default:"./cipher.txt"`
 	RetainCipherText bool   `short:"r" long:"retainCipherText" description:"Retain ciphertext after decryption"`
 	TargetFilepath   string `short:"t" long:"targetFilepath" description:"Path of file to write decrypted string to" default:"./plain.txt"`
 	Validate         bool   `short:"v" long:"validate" description:"Validate decryption works"`
@@ -44,98 +47,305 @@ type DecryptCommand struct {
 var decryptCommand DecryptCommand
 
 //Execute executes the DecryptCommand
-func (x *DecryptCommand) Execute(args []string) error {
+func (x *DecryptCommand) Execute(args []string) (err error) {
+	if err = x.checkFilepaths(); err != nil {
+		return
+	}
 	if !x.WriteToStdout {
 		fmt.Println("Decrypting...")
 	}
-	plaintext, err := PlainText(x.Filepath)
-	outputFilepath := x.TargetFilepath
-	fileMode := os.FileMode.Perm(0644)
+	kmsProvider, err := getDecryptKmsProvider()
+	if err != nil {
+		return
+	}
 	if x.Validate {
-		fmt.Println("Validation completed successfully")
-		os.Exit(0)
+		return x.validate(kmsProvider)
+	}
+	if err = x.writePlainText(kmsProvider); err != nil {
+		return
+	}
+	return x.deleteCipherText()
+}
+
+//checkFilepaths fails if the plaintext would be written over the ciphertext
+//file before it's read
+func (x *DecryptCommand) checkFilepaths() error {
+	if !x.WriteToStdout && sameFilepath(x.Filepath, x.TargetFilepath) {
+		return errors.New("filepath and targetFilepath must be different files")
+	}
+	return nil
+}
+
+//deleteCipherText zerofills and deletes the ciphertext file, unless it's being
+//retained or was read from stdin
+func (x *DecryptCommand) deleteCipherText() error {
+	if x.RetainCipherText || x.Filepath == stdioFilepath {
+		return nil
+	}
+	return secureDelete(x.Filepath, x.WriteToStdout)
+}
+
+//validate decrypts the ciphertext without writing the plaintext anywhere
+func (x *DecryptCommand) validate(kmsProvider KmsProvider) (err error) {
+	if err = decryptFile(x.Filepath, ioutil.Discard, kmsProvider); err != nil {
+		return
 	}
+	fmt.Println("Validation completed successfully")
+	os.Exit(0)
+	return
+}
+
+//writePlainText decrypts the ciphertext to either the console or the target
+//file, removing the target file if decryption fails part way through
+func (x *DecryptCommand) writePlainText(kmsProvider KmsProvider) (err error) {
+	outputFilepath := x.TargetFilepath
+	fileMode := os.FileMode.Perm(0644)
 	if x.WriteToStdout {
-		fmt.Printf("%s\n", plaintext)
-	} else {
-		err = ioutil.WriteFile(outputFilepath, plaintext, fileMode)
-		check(err)
-		fmt.Printf("Decryption successful, plaintext available at %s\n",
-			outputFilepath)
+		if err = decryptFile(x.Filepath, os.Stdout, kmsProvider); err == nil {
+			fmt.Println()
+		}
+		return
+	}
+	output, err := os.OpenFile(outputFilepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		fileMode)
+	if err != nil {
+		return
+	}
+	if err = decryptFile(x.Filepath, output, kmsProvider); err != nil {
+		output.Close()
+		secureDelete(outputFilepath, true)
+		return
 	}
-	if !x.RetainCipherText {
-		check(secureDelete(x.Filepath, x.WriteToStdout))
+	if err = output.Close(); err != nil {
+		return
+	}
+	fmt.Printf("Decryption successful, plaintext available at %s\n",
+		outputFilepath)
+	return
+}
+
+//decryptFile streams the ciphertext file ("-" for stdin) through
+//decryptInput into w
+func decryptFile(filepath string, w io.Writer, kmsProvider KmsProvider) (err error) {
+	input, err := openInput(filepath)
+	if err != nil {
+		return
+	}
+	defer input.Close()
+	return decryptInput(w, input, kmsProvider)
+}
+
+//decryptInput streams the ciphertext read from r through DecryptStream into
+//w, or decrypts the values of a structured file (see DecryptStructured) when
+//there's a --format
+func decryptInput(w io.Writer, r io.Reader, kmsProvider KmsProvider) error {
+	if defaultOptions.Format == "" {
+		return DecryptStream(w, r, kmsProvider, defaultOptions.AAD)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	plaintext, err := DecryptStructured(data, defaultOptions.Format, kmsProvider,
+		defaultOptions.AAD)
+	defer zeroBytes(plaintext)
+	if err == nil {
+		_, err = w.Write(plaintext)
 	}
 	return err
 }
 
-func checkCipherTextLength(ciphertext []byte, encDekLength int) {
+//DecryptStream decrypts base64 encoded ciphertext read from r, using the
+//KmsProvider to decrypt the DEK and the AAD it was encrypted with, and writes
+//the plaintext to w. Streamed ciphertexts are decrypted a chunk at a time, see
+//NewDecryptReader.
+func DecryptStream(w io.Writer, r io.Reader, kmsProvider KmsProvider,
+	aad AAD) (err error) {
+	plaintext, err := NewDecryptReader(base64.NewDecoder(base64.StdEncoding, r),
+		kmsProvider, aad)
+	if err == nil {
+		_, err = io.Copy(w, plaintext)
+	}
+	var corruptInput base64.CorruptInputError
+	if errors.As(err, &corruptInput) {
+		err = fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return
+}
+
+func checkCipherTextLength(ciphertext []byte, encDekLength int) error {
 	length := len(ciphertext)
 	minLength := encDekLength + nonceLength
 	if length < minLength {
-		panic("CipherText was shorter (" + strconv.Itoa(length) +
-			") than the smallest possible generated CipherText (" +
-			strconv.Itoa(minLength) + ")")
+		return fmt.Errorf("%w: CipherText was shorter (%d) than the smallest "+
+			"possible generated CipherText (%d)", ErrCiphertextTooShort, length,
+			minLength)
 	}
+	return nil
 }
 
 // PlainText returns a slice of bytes (the plaintext), decrypted from File
 func PlainText(filepath string) (plaintext []byte, err error) {
 	file, err := os.Open(filepath)
-	check(err)
+	if err != nil {
+		return
+	}
 	defer file.Close()
 	s := bufio.NewScanner(file)
 	var buffer bytes.Buffer
 	for s.Scan() {
 		buffer.WriteString(s.Text())
 	}
+	if err = s.Err(); err != nil {
+		return
+	}
 	cipherBytes, err := base64.StdEncoding.DecodeString(buffer.String())
-	check(err)
-	plaintext, err = PlainTextFromBytes(cipherBytes)
-	return
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return PlainTextFromBytes(cipherBytes)
 }
 
 // PlainTextFromBytes returns a slice of bytes (the plaintext), decrypted from
 // a byte slice
 func PlainTextFromBytes(cipherBytes []byte) (plaintext []byte, err error) {
 
-	kmsProvider, err := getKmsProvider(defaultOptions.KMSProvider)
-	check(err)
-	return PlainTextFromPrimitives(cipherBytes, defaultOptions.ProjectID,
-		defaultOptions.LocationID, defaultOptions.KeyRingID,
-		defaultOptions.CryptoKeyID, defaultOptions.KeyName, kmsProvider)
+	kmsProvider, err := getDecryptKmsProvider()
+	if err != nil {
+		return
+	}
+	return PlainTextFromPrimitives(cipherBytes, kmsProvider, defaultOptions.AAD)
 }
 
 // PlainTextFromPrimitives returns a slice of bytes (the plaintext), decrypted from
-// a byte slice
+// a byte slice, using the KmsProvider to decrypt the DEK. The AAD must match
+// the AAD the ciphertext was encrypted with, otherwise ErrAuthenticationFailed
+// is returned.
 func PlainTextFromPrimitives(cipherBytes []byte,
-	projectID, locationID, keyRingID, cryptoKeyID, keyName string,
-	kmsProvider KmsProvider) (plaintext []byte, err error) {
+	kmsProvider KmsProvider, aad AAD) (plaintext []byte, err error) {
 
-	checkCipherTextLength(cipherBytes, kmsProvider.encryptedDekLength())
-	cipherLength := len(cipherBytes)
-	encrypt := false
-	if plaintext, err = plainTextWithDekLength(cipherBytes, projectID, locationID, keyRingID,
-		cryptoKeyID, keyName, kmsProvider.encryptedDekLength(), cipherLength, encrypt, kmsProvider); err != nil {
-		plaintext, err = plainTextWithDekLength(cipherBytes, projectID, locationID, keyRingID,
-			cryptoKeyID, keyName, kmsProvider.encryptedDekLength()-1, cipherLength, encrypt, kmsProvider)
+	if !hasHeader(cipherBytes) {
+		return plainTextLegacy(cipherBytes, kmsProvider, aad)
+	}
+	if _, _, _, err = parseHeader(cipherBytes); err == nil {
+		return plainTextWithHeader(cipherBytes, kmsProvider, aad)
+	}
+	// the sealed data of a legacy ciphertext can start with the header magic by
+	// chance, so it's decrypted as one, returning the header's error if it fails
+	if plaintext, legacyErr := plainTextLegacy(cipherBytes, kmsProvider,
+		aad); legacyErr == nil {
+		return plaintext, nil
+	}
+	return nil, err
+}
+
+//plainTextLegacy decrypts a legacy ciphertext, i.e. one with no header, where
+//the encrypted DEK length is that of the provider detected for it
+func plainTextLegacy(cipherBytes []byte, kmsProvider KmsProvider,
+	aad AAD) (plaintext []byte, err error) {
+	if kmsProvider, err = legacyProviderFor(kmsProvider, cipherBytes); err != nil {
+		return
+	}
+	legacyProvider, ok := kmsProvider.(legacyKmsProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: CipherText has no header, and KMS Provider %s "+
+			"doesn't support legacy CipherTexts", ErrInvalidCiphertext,
+			kmsProvider.Name())
+	}
+	encDekLength := legacyProvider.encryptedDekLength()
+	if err = checkCipherTextLength(cipherBytes, encDekLength); err != nil {
+		return
+	}
+	if plaintext, err = plainTextWithDekLength(cipherBytes, encDekLength,
+		kmsProvider, aad); err != nil {
+		plaintext, err = plainTextWithDekLength(cipherBytes, encDekLength-1,
+			kmsProvider, aad)
 	}
 	return
 }
 
-func plainTextWithDekLength(cipherBytes []byte,
-	projectID, locationID, keyRingID, cryptoKeyID, keyName string,
-	encDekLength, cipherLength int,
-	encrypt bool,
-	kmsProvider KmsProvider) (plaintext []byte, err error) {
+//legacyProviderFor returns the provider detected for a legacy ciphertext, or
+//just the KmsProvider itself
+func legacyProviderFor(kmsProvider KmsProvider, cipherBytes []byte) (KmsProvider,
+	error) {
+	if detectingProvider, ok := kmsProvider.(detectingKmsProvider); ok {
+		return detectingProvider.detectLegacyProvider(cipherBytes)
+	}
+	return kmsProvider, nil
+}
+
+func plainTextWithDekLength(cipherBytes []byte, encDekLength int,
+	kmsProvider KmsProvider, aad AAD) (plaintext []byte, err error) {
 
+	cipherLength := len(cipherBytes)
 	encryptedDek := cipherBytes[cipherLength-encDekLength : cipherLength]
 	nonce := cipherBytes[cipherLength-(encDekLength+nonceLength) : cipherLength-encDekLength]
 	var decryptedDek []byte
-	if decryptedDek, err = kmsProvider.crypto(encryptedDek, projectID,
-		locationID, keyRingID, cryptoKeyID, keyName, encrypt); err == nil {
-		plaintext = cipherText(cipherBytes[0:len(cipherBytes)-(encDekLength+nonceLength)],
-			cipherblock(decryptedDek), nonce, encrypt)
+	if decryptedDek, err = kmsProvider.Decrypt(context.Background(),
+		encryptedDek); err == nil {
+		plaintext, err = cipherText(cipherBytes[0:cipherLength-(encDekLength+nonceLength)],
+			decryptedDek, nonce, aad.bytes(), false)
+	}
+	return
+}
+
+//plainTextWithHeader decrypts a ciphertext in the versioned format, where the
+//header records the encrypted DEK and nonce lengths, so no guessing is needed
+func plainTextWithHeader(cipherBytes []byte,
+	kmsProvider KmsProvider, aad AAD) (plaintext []byte, err error) {
+
+	h, rawHeader, data, err := parseHeader(cipherBytes)
+	if err != nil {
+		return
+	}
+	if h.chunkSize > 0 {
+		var r io.Reader
+		if r, err = NewDecryptReader(bytes.NewReader(cipherBytes), kmsProvider,
+			aad); err != nil {
+			return
+		}
+		return ioutil.ReadAll(r)
+	}
+	var decryptedDek []byte
+	if decryptedDek, err = decryptDek(h, kmsProvider); err == nil {
+		plaintext, err = cipherText(data, decryptedDek, h.nonce,
+			additionalData(rawHeader, aad), false)
 	}
 	return
 }
+
+//decryptDek uses the KmsProvider to decrypt the DEK in the header, checking
+//it's the provider the DEK was encrypted with, and replaying the encryption
+//context the DEK was bound to. A MultiKms or ThresholdKms unwraps with each of
+//its providers, and other providers can unwrap the parts of a DEK wrapped by
+//one of their keys for them. A DetectedKms uses the providers and keys
+//recorded in the header.
+func decryptDek(h header, kmsProvider KmsProvider) (dek []byte, err error) {
+	threshold, wrapped, err := wrappedDeks(h)
+	if err != nil {
+		return
+	}
+	kmsProviders, err := providersFor(kmsProvider, wrapped)
+	if err != nil {
+		return
+	}
+	if threshold > 0 {
+		return unwrapShares(context.Background(), kmsProviders, threshold, wrapped)
+	}
+	return unwrapAny(context.Background(), kmsProviders, wrapped)
+}
+
+//wrappedDeks returns the wrapped DEKs recorded in the header, i.e. those
+//listed in the encrypted DEK of a MULTI or THRESHOLD header (along with the
+//threshold), or else the header's own encrypted DEK
+func wrappedDeks(h header) (threshold int, wrapped []wrappedDek, err error) {
+	switch strings.ToUpper(h.providerID) {
+	case multiProviderName:
+		wrapped, err = decodeWrappedDeks(h.encryptedDek)
+		return
+	case thresholdProviderName:
+		return decodeThreshold(h.encryptedDek)
+	}
+	return 0, []wrappedDek{{h.providerID, h.keyID, h.encryptionContext,
+		h.encryptedDek}}, nil
+}
@@ -15,15 +15,87 @@
 package crypt
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 )
 
 func TestMinimumCipherTextLength(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("The code did not panic")
-		}
-	}()
 	plaintext := []byte("I'm Very Short")
-	checkCipherTextLength(plaintext, 20)
+	err := checkCipherTextLength(plaintext, 20)
+	if !errors.Is(err, ErrCiphertextTooShort) {
+		t.Errorf("Got %v, want %v", err, ErrCiphertextTooShort)
+	}
+}
+
+//legacyWithHeaderMagic returns a legacy ciphertext, with its DEK "encrypted"
+//by stubKms, whose sealed data starts with the header magic, and its plaintext
+func legacyWithHeaderMagic(t *testing.T) (legacy, plaintext []byte) {
+	dek := bytes.Repeat([]byte{1}, dekLength)
+	nonce := bytes.Repeat([]byte{2}, nonceLength)
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GCM XORs the plaintext with a keystream, so sealing zeros reveals it
+	plaintext = gcm.Seal(nil, nonce, make([]byte, 16), nil)[:16]
+	for i := range headerMagic {
+		plaintext[i] ^= headerMagic[i]
+	}
+	legacy = append(gcm.Seal(nil, nonce, plaintext, nil), nonce...)
+	return append(legacy, dek...), plaintext
+}
+
+func TestDecryptLegacyWithHeaderMagic(t *testing.T) {
+	legacy, plaintext := legacyWithHeaderMagic(t)
+	if !hasHeader(legacy) {
+		t.Fatal("Expected the legacy ciphertext to start with the header magic")
+	}
+	result, err := PlainTextFromPrimitives(legacy, stubKms{}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Got %x (%v), want %x", result, err, plaintext)
+	}
+	// an invalid header that isn't a legacy ciphertext still fails as one
+	legacy[len(legacy)-1] ^= 1
+	if _, err = PlainTextFromPrimitives(legacy, stubKms{}, nil); !errors.Is(err,
+		ErrUnsupportedVersion) {
+		t.Errorf("Got %v, want %v", err, ErrUnsupportedVersion)
+	}
+}
+
+func TestDecryptStreamLegacyWithHeaderMagic(t *testing.T) {
+	legacy, plaintext := legacyWithHeaderMagic(t)
+	var out bytes.Buffer
+	err := DecryptStream(&out, bytes.NewBufferString(
+		base64.StdEncoding.EncodeToString(legacy)), stubKms{}, nil)
+	if err != nil || !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("Got %x (%v), want %x", out.Bytes(), err, plaintext)
+	}
+}
+
+func TestDecryptOntoCipherTextFile(t *testing.T) {
+	useStubProvider(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cipher.txt")
+	if err := CipherText([]byte("helloworld"), path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := ioutil.ReadFile(path)
+	x := DecryptCommand{Filepath: path,
+		TargetFilepath: filepath.Join(dir, ".", "cipher.txt")}
+	if err := x.Execute(nil); err == nil {
+		t.Error("Expected error decrypting a file onto itself")
+	}
+	if ciphertext, _ := ioutil.ReadFile(path); !bytes.Equal(ciphertext, original) {
+		t.Errorf("Expected the ciphertext to be unchanged, got %q", ciphertext)
+	}
 }
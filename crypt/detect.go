@@ -0,0 +1,187 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+//DetectedKms decrypts using the KMS providers and keys recorded in each
+//ciphertext's header, so nothing but the provider's credentials (and config
+//that isn't recorded, e.g. Vault's address) needs to be given. The Opts are
+//used for everything else, including the key of ciphertexts that don't record
+//one. It can't encrypt.
+type DetectedKms struct {
+	Opts Defaults
+}
+
+//Name returns "DETECTED"
+func (d DetectedKms) Name() string {
+	return "DETECTED"
+}
+
+//Encrypt fails, as there's nothing to detect the provider from
+func (d DetectedKms) Encrypt(ctx context.Context, dek []byte) ([]byte, error) {
+	return nil, errors.New("a KMS provider (-m) or key (--key) is needed to encrypt")
+}
+
+//Decrypt fails, as a DEK on its own doesn't record its provider
+func (d DetectedKms) Decrypt(ctx context.Context, encryptedDek []byte) ([]byte,
+	error) {
+	return nil, errors.New("a KMS provider (-m) or key (--key) is needed to " +
+		"decrypt a DEK outside of a ciphertext")
+}
+
+//detectProviders creates a provider for each distinct key that wrapped the
+//DEKs. Providers that can't be created, e.g. as they're not configured, are
+//skipped, so long as another provider can be.
+func (d DetectedKms) detectProviders(wrapped []wrappedDek) (kmsProviders []KmsProvider,
+	err error) {
+	detected := map[[2]string]bool{}
+	for _, w := range wrapped {
+		if detected[[2]string{w.providerID, w.keyID}] {
+			continue
+		}
+		detected[[2]string{w.providerID, w.keyID}] = true
+		var kmsProvider KmsProvider
+		if kmsProvider, err = d.newProvider(w.providerID, w.keyID); err == nil {
+			kmsProviders = append(kmsProviders, kmsProvider)
+		}
+	}
+	if len(kmsProviders) > 0 {
+		err = nil
+	}
+	return
+}
+
+//newProvider creates the provider from the Opts, using the keyID as the
+//keyName if it was recorded
+func (d DetectedKms) newProvider(providerID, keyID string) (kmsProvider KmsProvider,
+	err error) {
+	if keyID != "" {
+		kmsProvider, err = newRecordedProvider(providerID, keyID, d.Opts)
+	} else {
+		kmsProvider, err = NewKmsProvider(providerID, d.Opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CipherText was encrypted with KMS Provider %s: %w",
+			providerID, err)
+	}
+	return kmsProvider, nil
+}
+
+//newRecordedProvider creates the provider for the key ID recorded in a
+//ciphertext. As the ciphertext can't be trusted, the provider checks the key
+//ID doesn't send requests, or credentials, anywhere it isn't configured to.
+func newRecordedProvider(providerID, keyID string, opts Defaults) (KmsProvider,
+	error) {
+	opts.KeyName = keyID
+	kmsProvider, err := NewKmsProvider(providerID, opts)
+	if err != nil {
+		return nil, err
+	}
+	keyIDProvider, ok := kmsProvider.(keyIDKmsProvider)
+	if !ok {
+		return nil, fmt.Errorf("KMS provider %s doesn't record key IDs", providerID)
+	}
+	if err = keyIDProvider.checkKeyID(); err != nil {
+		return nil, fmt.Errorf("untrusted key ID: %w", err)
+	}
+	return kmsProvider, nil
+}
+
+//detectLegacyProvider returns AWS if the ciphertext ends with what looks like
+//an aws kms ciphertext blob, as only AWS and GCP ciphertexts predate the
+//header, and GCP otherwise
+func (d DetectedKms) detectLegacyProvider(cipherBytes []byte) (KmsProvider, error) {
+	providerID := "GCP"
+	if awsCiphertextBlobLength(cipherBytes) > 0 {
+		providerID = "AWS"
+	}
+	return d.newProvider(providerID, "")
+}
+
+//recordedKmsProvider creates a KmsProvider for the keys recorded in the
+//ciphertext (or structured file with --format) read from r, needing the same
+//threshold of them, unless -m or --key give others. It's used to encrypt a
+//ciphertext again the way it was encrypted.
+func recordedKmsProvider(r io.Reader) (KmsProvider, error) {
+	if defaultOptions.KMSProvider != "" || len(defaultOptions.Keys) > 0 {
+		return getKmsProvider(defaultOptions.KMSProvider)
+	}
+	threshold, kmsProviders, err := recordedProviders(r)
+	if err != nil {
+		return nil, err
+	}
+	if threshold > 1 {
+		return ThresholdKms{Threshold: threshold, Providers: kmsProviders}, nil
+	}
+	if len(kmsProviders) == 1 {
+		return kmsProviders[0], nil
+	}
+	return MultiKms{Providers: kmsProviders}, nil
+}
+
+//recordedProviders creates a provider for each KMS key recorded in the
+//ciphertext read from r, as DetectedKms does, bound to the encryption context
+//recorded with it, and returns the threshold of them needed to decrypt it
+func recordedProviders(r io.Reader) (threshold int, kmsProviders []KmsProvider,
+	err error) {
+	envelope, err := inspectRecorded(r)
+	if err != nil {
+		return
+	}
+	for _, key := range envelope.Keys {
+		detected := DetectedKms{Opts: defaultOptions}
+		if len(key.EncryptionContext) > 0 {
+			detected.Opts.EncryptionContext = key.EncryptionContext
+		}
+		kmsProvider, err := detected.newProvider(key.Provider, key.KeyID)
+		if err != nil {
+			return 0, nil, err
+		}
+		kmsProviders = append(kmsProviders, kmsProvider)
+	}
+	return envelope.Threshold, kmsProviders, nil
+}
+
+//inspectRecorded returns what's recorded in the header of the ciphertext, or
+//structured file with --format, read from r
+func inspectRecorded(r io.Reader) (Envelope, error) {
+	if defaultOptions.Format != "" {
+		return inspectStructured(r)
+	}
+	return Inspect(base64.NewDecoder(base64.StdEncoding, r))
+}
+
+//inspectStructured returns the keys recorded in the header of the encrypted
+//structured file read from r, and the threshold of them needed to decrypt it
+func inspectStructured(r io.Reader) (envelope Envelope, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	h, err := structuredHeader(data, defaultOptions.Format)
+	if err != nil {
+		return
+	}
+	threshold, wrapped, err := wrappedDeks(h)
+	return Envelope{Threshold: threshold, Keys: envelopeKeys(wrapped)}, err
+}
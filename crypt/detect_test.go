@@ -0,0 +1,211 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDetectedKms(t *testing.T) {
+	_, server := newFakeVault(t)
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, VaultKms{KeyName: "mantle",
+		Address: server.URL, Token: "token"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _, _, _ := parseHeader(sealed); h.keyID != "mantle" {
+		t.Errorf("Got key ID %q, want mantle", h.keyID)
+	}
+	t.Setenv("VAULT_TOKEN", "token")
+	t.Setenv("VAULT_ADDR", server.URL)
+	// the recorded key is used rather than the keyName
+	result, err := PlainTextFromPrimitives(sealed, DetectedKms{Opts: Defaults{
+		KeyName: "other"}}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Decryption with the detected provider failed (%v)", err)
+	}
+}
+
+func TestDetectedKmsMulti(t *testing.T) {
+	_, server := newFakeVault(t)
+	local := newTestLocalKms(t)
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, MultiKms{Providers: []KmsProvider{
+		local, VaultKms{KeyName: "mantle", Address: server.URL, Token: "token"}}},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAULT_TOKEN", "token")
+	// without a LOCAL key, the LOCAL provider can't be created, so Vault's used
+	t.Setenv(localKeyEnvVar, "")
+	t.Setenv("VAULT_ADDR", server.URL)
+	result, err := PlainTextFromPrimitives(sealed, DetectedKms{}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Decryption with the detected Vault provider failed (%v)", err)
+	}
+	t.Setenv(localKeyEnvVar, base64.StdEncoding.EncodeToString(local.Key))
+	result, err = PlainTextFromPrimitives(sealed, DetectedKms{}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Decryption with the detected LOCAL provider failed (%v)", err)
+	}
+}
+
+var recordedKeyIDTests = []struct {
+	providerID, keyID string
+	trusted           bool
+}{
+	{"AWS", "arn:aws:kms:eu-west-1:111122223333:key/1234abcd", true},
+	{"AWS", "alias/mantle", true},
+	{"AWS", "arn:aws:kms:evil.example.com/eu-west-1:111122223333:key/1234abcd", false},
+	{"AWS", "arn:aws:s3:eu-west-1:111122223333:key/1234abcd", false},
+	{"AZURE", "https://v.vault.azure.net/keys/k/1a2b", true},
+	{"AZURE", "https://evil.example.com/keys/k", false},
+	{"AZURE", "http://v.vault.azure.net/keys/k", false},
+	{"GCP", "projects/p/locations/global/keyRings/r/cryptoKeys/k", true},
+	{"GCP", "projects/p/locations/global/keyRings/r/cryptoKeys/k/../../x", false},
+	{"GCP", "projects/../locations/global/keyRings/r/cryptoKeys/k", false},
+	{"VAULT", "mantle", true},
+	{"VAULT", "../../sys/mounts", false},
+	{"LOCAL", "key", false},
+}
+
+func TestNewRecordedProvider(t *testing.T) {
+	t.Setenv(localKeyEnvVar, base64.StdEncoding.EncodeToString(
+		newTestLocalKms(t).Key))
+	for _, test := range recordedKeyIDTests {
+		_, err := newRecordedProvider(test.providerID, test.keyID, Defaults{})
+		if (err == nil) != test.trusted {
+			t.Errorf("Creating %s with key ID %s got %v", test.providerID,
+				test.keyID, err)
+		}
+	}
+	// requests for the recorded key go to the configured endpoint
+	t.Setenv("MANTLE_AZURE_KMS_ENDPOINT", "https://private.example.com")
+	if _, err := newRecordedProvider("AZURE", "https://evil.example.com/keys/k",
+		Defaults{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDetectedKmsUntrustedKeyID(t *testing.T) {
+	fake := newFakeKeyVault(t)
+	sealed, err := sealWithNewDek([]byte("helloworld"),
+		AzureKms{KeyName: fake.url + "/keys/mantle"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = PlainTextFromPrimitives(sealed, DetectedKms{}, nil); err == nil {
+		t.Error("Expected the untrusted key ID to be rejected")
+	}
+}
+
+//registeredContextKms is a contextKms registered as a provider, binding the
+//--encryptionContext to the DEK
+type registeredContextKms struct {
+	contextKms
+}
+
+func newRegisteredContextKms(opts Defaults) (KmsProvider, error) {
+	return registeredContextKms{contextKms{context: opts.EncryptionContext}}, nil
+}
+
+func (r registeredContextKms) Name() string {
+	return "CONTEXT"
+}
+
+func TestRecordedKmsProviderEncryptionContext(t *testing.T) {
+	RegisterProvider("CONTEXT", newRegisteredContextKms)
+	encryptionContext := map[string]string{"service": "billing"}
+	plaintext := []byte("helloworld")
+	cipherBytes, err := CipherBytesFromPrimitives(plaintext, false, true,
+		registeredContextKms{contextKms{context: encryptionContext}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kmsProvider, err := recordedKmsProvider(bytes.NewReader(cipherBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// encrypting again binds the DEK to the recorded encryption context
+	if cipherBytes, err = CipherBytesFromPrimitives(plaintext, false, true,
+		kmsProvider, nil); err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := Inspect(base64.NewDecoder(base64.StdEncoding,
+		bytes.NewReader(cipherBytes)))
+	if err != nil || !reflect.DeepEqual(envelope.Keys[0].EncryptionContext,
+		encryptionContext) {
+		t.Errorf("Got %+v (%v), want encryption context %v", envelope, err,
+			encryptionContext)
+	}
+}
+
+//unregisteredKms is a stubKms that isn't registered as a provider
+type unregisteredKms struct {
+	stubKms
+}
+
+func (u unregisteredKms) Name() string {
+	return "UNREGISTERED"
+}
+
+func TestDetectedKmsUnsupported(t *testing.T) {
+	sealed, _ := sealWithNewDek([]byte("helloworld"), unregisteredKms{}, nil)
+	if _, err := PlainTextFromPrimitives(sealed, DetectedKms{}, nil); !errors.Is(err,
+		ErrUnsupportedProvider) {
+		t.Errorf("Got %v, want %v", err, ErrUnsupportedProvider)
+	}
+	if _, err := (DetectedKms{}).Encrypt(context.Background(), nil); err == nil {
+		t.Error("Expected error encrypting")
+	}
+}
+
+func TestDetectLegacyProvider(t *testing.T) {
+	awsBlob := append([]byte{1, 2, 2, 0, 0x78}, make([]byte, 180)...)
+	tests := []struct {
+		want        string
+		cipherBytes []byte
+	}{
+		{"AWS", append(make([]byte, 20+nonceLength), awsBlob...)},
+		{"AWS", append(make([]byte, 20+nonceLength), awsBlob[:184]...)},
+		{"GCP", make([]byte, 20+nonceLength+114)},
+	}
+	for _, test := range tests {
+		want, cipherBytes := test.want, test.cipherBytes
+		kmsProvider, err := DetectedKms{}.detectLegacyProvider(cipherBytes)
+		if err != nil || kmsProvider.Name() != want {
+			t.Errorf("Got %v (%v), want %s", kmsProvider, err, want)
+		}
+	}
+}
+
+func TestGetDecryptKmsProvider(t *testing.T) {
+	useStubProvider(t)
+	if kmsProvider, err := getDecryptKmsProvider(); err != nil ||
+		kmsProvider.Name() != "STUB" {
+		t.Errorf("Got %v (%v), want the -m provider", kmsProvider, err)
+	}
+	defaultOptions.KMSProvider = ""
+	if kmsProvider, _ := getDecryptKmsProvider(); kmsProvider.Name() != "DETECTED" {
+		t.Errorf("Got %v, want a DetectedKms", kmsProvider)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const envNameChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz" +
+	"0123456789_"
+
+//envVar is an environment variable parsed from a dotenv file
+type envVar struct {
+	name  string
+	value string
+}
+
+//String returns NAME=value, as used by os/exec
+func (v envVar) String() string {
+	return v.name + "=" + v.value
+}
+
+//dotenvLayout is how a variable was written in a dotenv file, so it can be
+//formatted the same way with a new value
+type dotenvLayout struct {
+	comments string //the blank and comment lines before it
+	prefix   string //up to the value, e.g. "export NAME = "
+	quote    byte   //the value's quote, or 0 if it's unquoted
+	trailing string //after the value, e.g. " # comment"
+}
+
+//parseDotenv parses dotenv formatted data, i.e. NAME=value lines, optionally
+//prefixed by export. Values can be unquoted (up to any # comment), 'single
+//quoted' as is, or "double quoted" with \n, \r, \t, \" and \\ escapes and
+//spanning several lines. Blank lines and # comments are skipped. Errors give
+//the line number, never the value, as it's a secret.
+func parseDotenv(data []byte) ([]envVar, error) {
+	vars, _, _, err := parseDotenvLayout(data)
+	return vars, err
+}
+
+//parseDotenvLayout parses dotenv formatted data like parseDotenv, also
+//returning the layout of each variable, and the lines after the last one
+func parseDotenvLayout(data []byte) (vars []envVar, layouts []dotenvLayout,
+	trailing string, err error) {
+	lines := strings.SplitAfter(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			trailing += lines[i]
+			continue
+		}
+		var v envVar
+		var layout dotenvLayout
+		start := i
+		if v, layout, i, err = parseDotenvVar(lines, i); err != nil {
+			return nil, nil, "", fmt.Errorf("dotenv line %d: %w", start+1, err)
+		}
+		layout.comments, trailing = trailing, ""
+		vars, layouts = append(vars, v), append(layouts, layout)
+	}
+	return
+}
+
+//parseDotenvVar parses the variable starting on line i, returning it and its
+//layout along with the line it ended on
+func parseDotenvVar(lines []string, i int) (v envVar, layout dotenvLayout,
+	end int, err error) {
+	line := trimNewline(lines[i])
+	eq := strings.Index(line, "=")
+	if eq < 0 {
+		return v, layout, i, errors.New("expected NAME=value")
+	}
+	v.name = strings.TrimSpace(strings.TrimPrefix(
+		strings.TrimLeft(line[:eq], " \t"), "export "))
+	if !isEnvName(v.name) {
+		return v, layout, i, errors.New("expected NAME=value")
+	}
+	value := strings.TrimLeft(line[eq+1:], " \t")
+	layout.prefix, end = line[:len(line)-len(value)], i
+	switch {
+	case strings.HasPrefix(value, `"`):
+		layout.quote = '"'
+		v.value, layout.trailing, end, err = doubleQuotedValue(lines, i, value[1:])
+	case strings.HasPrefix(value, "'"):
+		layout.quote = '\''
+		v.value, layout.trailing, err = singleQuotedValue(value[1:])
+	default:
+		v.value, layout.trailing = unquotedValue(value)
+	}
+	return
+}
+
+//trimNewline removes the line ending from a line
+func trimNewline(line string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
+
+//isEnvName reports whether the name is a valid environment variable name,
+//i.e. letters, digits and underscores, not starting with a digit
+func isEnvName(name string) bool {
+	return name != "" && strings.Trim(name, envNameChars) == "" &&
+		(name[0] < '0' || name[0] > '9')
+}
+
+//unquotedValue returns the value up to any comment, without surrounding
+//whitespace, along with what trails it
+func unquotedValue(value string) (string, string) {
+	end := strings.Index(value, " #")
+	if end < 0 {
+		end = len(value)
+	}
+	unquoted := strings.TrimRight(value[:end], " \t")
+	return unquoted, value[len(unquoted):]
+}
+
+//singleQuotedValue returns the value up to the closing quote, as is, along
+//with what trails it
+func singleQuotedValue(value string) (string, string, error) {
+	i := strings.Index(value, "'")
+	if i < 0 {
+		return "", "", errors.New("unterminated single quoted value")
+	}
+	return value[:i], value[i+1:], checkTrailing(value[i+1:])
+}
+
+//doubleQuotedValue returns the unescaped value up to the closing quote,
+//reading further lines until it's found, along with what trails it
+func doubleQuotedValue(lines []string, i int, value string) (string, string,
+	int, error) {
+	for {
+		if end := closingQuote(value); end >= 0 {
+			return unescapeDotenv(value[:end]), value[end+1:], i,
+				checkTrailing(value[end+1:])
+		}
+		if i++; i == len(lines) {
+			return "", "", i, errors.New("unterminated double quoted value")
+		}
+		value += "\n" + trimNewline(lines[i])
+	}
+}
+
+//closingQuote returns the index of the first unescaped double quote, or -1
+func closingQuote(value string) int {
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+var dotenvUnescaper = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t",
+	`\"`, `"`, `\\`, `\`)
+
+//dotenvEscaper escapes a double quoted value, leaving any new lines, so it
+//spans lines as e.g. a PEM key would
+var dotenvEscaper = strings.NewReplacer("\r", `\r`, "\t", `\t`, `"`, `\"`,
+	`\`, `\\`)
+
+//unescapeDotenv replaces the escapes in a double quoted value
+func unescapeDotenv(value string) string {
+	return dotenvUnescaper.Replace(value)
+}
+
+//format formats the variable as it was laid out, with the value given,
+//quoting it differently only if it couldn't be parsed back otherwise. Double
+//quoted values are escaped afresh, so e.g. \n becomes a new line.
+func (l dotenvLayout) format(value string) string {
+	quoted := value
+	switch l.quoteFor(value) {
+	case '\'':
+		quoted = "'" + value + "'"
+	case '"':
+		quoted = `"` + dotenvEscaper.Replace(value) + `"`
+	}
+	return l.comments + l.prefix + quoted + l.trailing + "\n"
+}
+
+//quoteFor returns the layout's quote, unless the value needs another
+func (l dotenvLayout) quoteFor(value string) byte {
+	switch {
+	case l.quote == '"',
+		l.quote == '\'' && !strings.ContainsAny(value, "'\n\r"),
+		l.quote == 0 && isUnquotable(value):
+		return l.quote
+	case l.quote == 0:
+		return dotenvQuote(value)
+	}
+	return '"'
+}
+
+//dotenvQuote returns the simplest quote needed for the value to be parsed
+//back as is
+func dotenvQuote(value string) byte {
+	switch {
+	case isUnquotable(value):
+		return 0
+	case !strings.ContainsAny(value, "'\n\r"):
+		return '\''
+	}
+	return '"'
+}
+
+//isUnquotable reports whether the value is parsed back as is without quotes
+func isUnquotable(value string) bool {
+	unquoted, _ := unquotedValue(value)
+	return unquoted == value && !strings.ContainsAny(value, "\n\r") &&
+		strings.TrimLeft(value, " \t'\"") == value
+}
+
+//checkTrailing checks only whitespace or a comment follows a quoted value
+func checkTrailing(trailing string) error {
+	trailing = strings.TrimSpace(trailing)
+	if trailing != "" && !strings.HasPrefix(trailing, "#") {
+		return errors.New("unexpected characters after quoted value")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	data := strings.Join([]string{
+		"# database",
+		"DB_USER=admin",
+		"export DB_PASSWORD = s3cr3t # rotated monthly",
+		"",
+		"EMPTY=",
+		`GREETING="hello \"world\"\n" # quoted`,
+		"LITERAL='a \\n $b'\r",
+		`PEM="-----BEGIN KEY-----`,
+		`abc`,
+		`-----END KEY-----"`,
+		"URL=https://example.com/#anchor",
+	}, "\n")
+	vars, err := parseDotenv([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []envVar{
+		{"DB_USER", "admin"},
+		{"DB_PASSWORD", "s3cr3t"},
+		{"EMPTY", ""},
+		{"GREETING", "hello \"world\"\n"},
+		{"LITERAL", `a \n $b`},
+		{"PEM", "-----BEGIN KEY-----\nabc\n-----END KEY-----"},
+		{"URL", "https://example.com/#anchor"},
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("Got %q, want %q", vars, want)
+	}
+}
+
+var invalidDotenvTests = map[string]string{
+	"no equals":           "A=b\nNOT_A_VAR",
+	"invalid name":        "1A=b",
+	"unterminated double": "A=\"b\nc",
+	"unterminated single": "A='b",
+	"trailing characters": "A=\"b\" c",
+}
+
+func TestParseInvalidDotenv(t *testing.T) {
+	for name, data := range invalidDotenvTests {
+		_, err := parseDotenv([]byte(data + "s3cr3t"))
+		if err == nil {
+			t.Errorf("Expected error parsing %s", name)
+		} else if strings.Contains(err.Error(), "s3cr3t") {
+			t.Errorf("Error parsing %s leaked the value: %v", name, err)
+		}
+	}
+}
+
+var dotenvFormatTests = []struct {
+	quote byte
+	value string
+	want  string
+}{
+	{0, "mantle:v1:str:YWJj", "A=mantle:v1:str:YWJj"},
+	{0, "b #c", "A='b #c'"},
+	{0, " b", "A=' b'"},
+	{0, "it's", "A=it's"},
+	{0, "'b'", `A="'b'"`},
+	{'\'', "b", "A='b'"},
+	{'\'', "it's", `A="it's"`},
+	{'"', "b", `A="b"`},
+	{'"', "a\"\\\tb\nc", "A=\"a\\\"\\\\\\tb\nc\""},
+}
+
+func TestDotenvLayoutFormat(t *testing.T) {
+	for _, test := range dotenvFormatTests {
+		layout := dotenvLayout{prefix: "A=", quote: test.quote}
+		formatted := layout.format(test.value)
+		if formatted != test.want+"\n" {
+			t.Errorf("Got %q, want %q", formatted, test.want)
+		}
+		vars, err := parseDotenv([]byte(formatted))
+		if err != nil || len(vars) != 1 || vars[0].value != test.value {
+			t.Errorf("Got %q (%v) parsing %q", vars, err, formatted)
+		}
+	}
+}
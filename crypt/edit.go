@@ -0,0 +1,308 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+func init() {
+	Parser.AddCommand("edit",
+		"Edits the plaintext of a ciphertext file in $EDITOR",
+		"Decrypts the ciphertext into a private temporary file, opens it in "+
+			"$EDITOR and, if it was changed, encrypts it again with the same KMS "+
+			"keys, atomically replacing the ciphertext. The temporary file is "+
+			"zerofilled and deleted afterwards, unless encrypting it fails.",
+		&editCommand)
+}
+
+//EditCommand type
+type EditCommand struct {
+	DisableValidation bool   `short:"d" long:"disableValidation" description:"Disable validation of ciphertext"`
+	Filepath          string `short:"f" long:"filepath" description:"Path of file to edit, if not given as an argument" default:"./cipher.txt"`
+	SingleLine        bool   `short:"s" long:"singleLine" description:"Disable use of newline chars in ciphertext"`
+}
+
+var editCommand EditCommand
+
+//Execute executes the EditCommand
+func (x *EditCommand) Execute(args []string) error {
+	if len(args) > 1 {
+		return errors.New("expected the path of one file to edit")
+	}
+	if len(args) == 1 {
+		x.Filepath = args[0]
+	}
+	return Edit(x.Filepath, x.SingleLine, x.DisableValidation)
+}
+
+//tmpfsDir is where the plaintext is decrypted to for editing if it exists, so
+//it's kept in memory rather than written to disk
+var tmpfsDir = "/dev/shm"
+
+//Edit decrypts the ciphertext file into a temporary file, and opens it in
+//$EDITOR. If it was changed, it's encrypted again with the KMS keys recorded
+//in the ciphertext (unless -m or --key give others), atomically replacing the
+//ciphertext file. The temporary file is zerofilled and deleted afterwards,
+//including if mantle is interrupted, but kept if encrypting it fails.
+func Edit(name string, singleLine, disableValidation bool) (err error) {
+	kmsProvider, err := editKmsProvider(name)
+	if err != nil {
+		return
+	}
+	s, err := newEditSession(name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := s.close(); err == nil {
+			err = closeErr
+		}
+	}()
+	changed, err := s.run(name)
+	if err != nil || !changed {
+		return
+	}
+	return s.save(name, singleLine, disableValidation, kmsProvider)
+}
+
+//editKmsProvider creates the KmsProvider to encrypt the edited plaintext
+//with, see recordedKmsProvider
+func editKmsProvider(name string) (KmsProvider, error) {
+	input, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	return recordedKmsProvider(input)
+}
+
+//editSession is the temporary file a ciphertext is decrypted into for
+//editing. If mantle is signalled, the file is zerofilled and deleted, other
+//than while the editor is running, as the signals are forwarded to it. It's
+//kept if encrypting the edited plaintext fails, so the edits aren't lost.
+type editSession struct {
+	file    *os.File
+	signals chan os.Signal
+	editing int32
+	keep    bool
+	wiped   sync.Once
+}
+
+//newEditSession creates the temporary file, which only the user can access,
+//in the tmpfsDir if it exists. It has the same extension as the ciphertext
+//file, so editors can highlight it.
+func newEditSession(name string) (s *editSession, err error) {
+	dir := os.TempDir()
+	if info, statErr := os.Stat(tmpfsDir); statErr == nil && info.IsDir() {
+		dir = tmpfsDir
+	}
+	s = &editSession{signals: make(chan os.Signal, 1)}
+	signal.Notify(s.signals, terminatingSignals...)
+	if s.file, err = ioutil.TempFile(dir, "mantle-edit-*"+
+		filepath.Ext(name)); err != nil {
+		signal.Stop(s.signals)
+		return nil, err
+	}
+	go s.wipeOnSignal()
+	return s, nil
+}
+
+//wipeOnSignal zerofills and deletes the file when a signal is received while
+//the editor isn't running, exiting as if killed by the signal
+func (s *editSession) wipeOnSignal() {
+	for sig := range s.signals {
+		if atomic.LoadInt32(&s.editing) == 0 {
+			// if close has already wiped the file, its error is returned instead
+			s.wiped.Do(func() {
+				s.wipe()
+				os.Exit(signalExitStatus(sig))
+			})
+		}
+	}
+}
+
+//signalExitStatus returns the exit status of a process killed by the signal
+func signalExitStatus(sig os.Signal) int {
+	if sig, ok := sig.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
+
+//close stops catching signals, and zerofills and deletes the file unless it's
+//kept. A signal already caught is ignored once it has, rather than wiping the
+//file again.
+func (s *editSession) close() (err error) {
+	signal.Stop(s.signals)
+	s.wiped.Do(func() {
+		err = s.wipe()
+	})
+	close(s.signals)
+	return
+}
+
+//wipe zerofills and deletes the file unless it's kept
+func (s *editSession) wipe() error {
+	s.file.Close()
+	if s.keep {
+		return nil
+	}
+	return secureDelete(s.file.Name(), false)
+}
+
+//run decrypts the ciphertext file into the temporary file and opens it in
+//the editor, reporting whether the plaintext was changed
+func (s *editSession) run(name string) (bool, error) {
+	kmsProvider, err := getDecryptKmsProvider()
+	if err != nil {
+		return false, err
+	}
+	original, err := s.decrypt(name, kmsProvider)
+	if err != nil {
+		return false, err
+	}
+	if err = s.edit(); err != nil {
+		return false, fmt.Errorf("%w, %s is unchanged", err, name)
+	}
+	edited, err := hashFile(s.file.Name())
+	if err == nil && bytes.Equal(original, edited) {
+		fmt.Fprintf(statusOutput, "No changes, %s is unchanged\n", name)
+	}
+	return !bytes.Equal(original, edited), err
+}
+
+//decrypt decrypts the ciphertext file into the temporary file, returning a
+//hash of the plaintext
+func (s *editSession) decrypt(name string, kmsProvider KmsProvider) ([]byte,
+	error) {
+	hash := sha256.New()
+	err := decryptFile(name, io.MultiWriter(s.file, hash), kmsProvider)
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return hash.Sum(nil), err
+}
+
+//edit opens the temporary file in $EDITOR, which may include arguments,
+//failing if it exits with a non-zero status
+func (s *editSession) edit() error {
+	atomic.StoreInt32(&s.editing, 1)
+	defer atomic.StoreInt32(&s.editing, 0)
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{defaultEditor}
+	}
+	code, err := runCommand(append(editor, s.file.Name()), os.Environ())
+	if err == nil && code != 0 {
+		err = fmt.Errorf("editor exited with status %d", code)
+	}
+	return err
+}
+
+//hashFile returns a hash of the file's contents
+func hashFile(name string) ([]byte, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	return hash.Sum(nil), err
+}
+
+//save encrypts the edited plaintext, keeping the temporary file if it fails,
+//so it can be encrypted once the problem's fixed
+func (s *editSession) save(name string, singleLine, disableValidation bool,
+	kmsProvider KmsProvider) error {
+	err := s.encrypt(name, singleLine, disableValidation, kmsProvider)
+	if err != nil {
+		s.keep = true
+		err = fmt.Errorf("%w, %s is unchanged, the edited plaintext is kept at "+
+			"%s, delete it once it's encrypted", err, name, s.file.Name())
+	}
+	return err
+}
+
+//encrypt encrypts the edited plaintext with the KmsProvider, atomically
+//replacing the ciphertext file, as reencrypt would
+func (s *editSession) encrypt(name string, singleLine, disableValidation bool,
+	kmsProvider KmsProvider) error {
+	if defaultOptions.Format != "" {
+		return s.encryptStructured(name, disableValidation, kmsProvider)
+	}
+	info, err := os.Stat(s.file.Name())
+	if err != nil {
+		return err
+	}
+	if info.Size() > StreamingThreshold {
+		err = encryptFile(s.file.Name(), name, singleLine, disableValidation,
+			kmsProvider)
+	} else {
+		err = s.encryptInMemory(name, singleLine, disableValidation, kmsProvider)
+	}
+	if err == nil {
+		fmt.Fprintf(statusOutput, "Encryption successful, ciphertext available at %s\n",
+			name)
+	}
+	return err
+}
+
+//encryptInMemory encrypts the edited plaintext in memory, like CipherText
+//without printing the ciphertext
+func (s *editSession) encryptInMemory(name string, singleLine,
+	disableValidation bool, kmsProvider KmsProvider) error {
+	plaintext, err := ioutil.ReadFile(s.file.Name())
+	defer zeroBytes(plaintext)
+	if err != nil {
+		return err
+	}
+	cipherBytes, err := CipherBytesFromPrimitives(plaintext, singleLine,
+		disableValidation, kmsProvider, defaultOptions.AAD)
+	if err != nil {
+		return err
+	}
+	return writeOutput(name, cipherBytes)
+}
+
+//encryptStructured encrypts the values of the edited structured plaintext,
+//keeping the key regex it was encrypted with, unless --keyRegex is given
+func (s *editSession) encryptStructured(name string, disableValidation bool,
+	kmsProvider KmsProvider) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	plaintext, err := ioutil.ReadFile(s.file.Name())
+	defer zeroBytes(plaintext)
+	if err != nil {
+		return err
+	}
+	return writeStructured(name, plaintext, reencryptKeyRegex(data),
+		disableValidation, kmsProvider)
+}
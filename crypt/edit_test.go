@@ -0,0 +1,119 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//editTest encrypts the plaintext to a new file, and sets $EDITOR to a script
+//recording the path of the file it edits before running the command, with
+//the kmsProvider flag unset, so edit detects the provider
+func editTest(t *testing.T, plaintext, command string) (dir, path string) {
+	useStubProvider(t)
+	dir = t.TempDir()
+	path = filepath.Join(dir, "cipher.txt")
+	if err := CipherText([]byte(plaintext), path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	defaultOptions.KMSProvider = ""
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\necho \"$1\" > " + filepath.Join(dir, "edited") + "\n" +
+		command + "\n"
+	if err := ioutil.WriteFile(editor, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", editor)
+	return
+}
+
+//checkEditFileDeleted checks the file the editor edited has been deleted
+func checkEditFileDeleted(t *testing.T, dir string) {
+	edited, err := ioutil.ReadFile(filepath.Join(dir, "edited"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(string(bytes.TrimSpace(edited))); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be deleted, got %v", edited, err)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	dir, path := editTest(t, "hello\n", `echo world >> "$1"`)
+	if err := Edit(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	checkEditFileDeleted(t, dir)
+	plaintext, err := PlainText(path)
+	if err != nil || string(plaintext) != "hello\nworld\n" {
+		t.Errorf("Got %q (%v), want the edited plaintext", plaintext, err)
+	}
+}
+
+func TestEditUnchanged(t *testing.T) {
+	for command, wantErr := range map[string]bool{"true": false, "exit 3": true} {
+		dir, path := editTest(t, "hello\n", command)
+		original, _ := ioutil.ReadFile(path)
+		if err := Edit(path, false, false); (err != nil) != wantErr {
+			t.Errorf("Got %v running %s", err, command)
+		}
+		checkEditFileDeleted(t, dir)
+		if ciphertext, _ := ioutil.ReadFile(path); !bytes.Equal(ciphertext, original) {
+			t.Errorf("Expected the ciphertext to be unchanged running %s", command)
+		}
+	}
+}
+
+func TestEditEncryptionFails(t *testing.T) {
+	// replacing the ciphertext with a directory stops it being written
+	dir, path := editTest(t, "hello\n",
+		`echo world >> "$1" && rm "$CIPHERTEXT" && mkdir "$CIPHERTEXT"`)
+	t.Setenv("CIPHERTEXT", path)
+	err := Edit(path, false, false)
+	edited, _ := ioutil.ReadFile(filepath.Join(dir, "edited"))
+	kept := string(bytes.TrimSpace(edited))
+	if err == nil || !strings.Contains(err.Error(), kept) {
+		t.Errorf("Got %v, want an error giving the path of the kept file", err)
+	}
+	plaintext, readErr := ioutil.ReadFile(kept)
+	if readErr != nil || string(plaintext) != "hello\nworld\n" {
+		t.Errorf("Got %q (%v), want the edited plaintext kept", plaintext, readErr)
+	}
+	os.Remove(kept)
+}
+
+func TestEditSessionCloseWipesOnce(t *testing.T) {
+	s, err := newEditSession("cipher.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(s.file.Name()); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be deleted, got %v", s.file.Name(), err)
+	}
+	// a signal caught while closing doesn't wipe the file again, or exit
+	s.wiped.Do(func() {
+		t.Error("Expected the file to have been wiped already")
+	})
+}
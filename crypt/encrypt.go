@@ -15,10 +15,14 @@
 package crypt
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 func init() {
@@ -34,27 +38,33 @@ func init() {
 //EncryptCommand type
 type EncryptCommand struct {
 	DisableValidation bool   `short:"d" long:"disableValidation" description:"Disable validation of ciphertext"`
-	Filepath          string `short:"f" long:"filepath" description:"Path of file to encrypt" default:"./plain.txt"`
+	Filepath          string `short:"f" long:"filepath" description:"Path of file to encrypt, or - for stdin" default:"./plain.txt"`
+	RetainPlainText   bool   `short:"r" long:"retainPlainText" description:"Retain plaintext after encryption"`
 	SingleLine        bool   `short:"s" long:"singleLine" description:"Disable use of newline chars in ciphertext"`
+	TargetFilepath    string `short:"t" long:"targetFilepath" description:"Path of file to write ciphertext to" default:"./cipher.txt"`
+	WriteToStdout     bool   `short:"o" long:"stdout" description:"Writes only the ciphertext to console, with progress messages written to stderr"`
 }
 
 var encryptCommand EncryptCommand
 
 //Execute executes the EncryptCommand
 func (x *EncryptCommand) Execute(args []string) (err error) {
-	fmt.Println("Encrypting...")
-	dat, err := ioutil.ReadFile(x.Filepath)
-	check(err)
-	err = CipherText(dat, x.Filepath, x.SingleLine, x.DisableValidation)
-	check(secureDelete(x.Filepath, false))
-	return err
+	outputFilepath := x.TargetFilepath
+	if x.WriteToStdout {
+		statusOutput = os.Stderr
+		outputFilepath = stdioFilepath
+	}
+	fmt.Fprintln(statusOutput, "Encrypting...")
+	return CipherTextFile(x.Filepath, outputFilepath, x.SingleLine,
+		x.DisableValidation, x.RetainPlainText)
 }
 
+const newLineInterval = 40
+
 //insertNewLines inserts a newline char at specific intervals
 func insertNewLines(cipherTexts []byte) (newLineText []byte) {
-	interval := 40
 	for i, char := range cipherTexts {
-		if i > 0 && (i%interval == 0) {
+		if i > 0 && (i%newLineInterval == 0) {
 			newLineText = append(newLineText, []byte("\n")...)
 		}
 		newLineText = append(newLineText, char)
@@ -62,59 +72,338 @@ func insertNewLines(cipherTexts []byte) (newLineText []byte) {
 	return
 }
 
+//newLineWriter inserts a newline char at the same intervals as
+//insertNewLines, into text written to it a piece at a time
+type newLineWriter struct {
+	w      io.Writer
+	column int
+}
+
+func (l *newLineWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if l.column == newLineInterval {
+			if _, err = l.w.Write([]byte("\n")); err != nil {
+				return
+			}
+			l.column = 0
+		}
+		line := p
+		if len(line) > newLineInterval-l.column {
+			line = line[:newLineInterval-l.column]
+		}
+		var written int
+		written, err = l.w.Write(line)
+		n += written
+		l.column += written
+		if err != nil {
+			return
+		}
+		p = p[written:]
+	}
+	return
+}
+
 //CipherText creates a ciphertext encrypted from a slice of bytes
-//(the plaintext), and writes to File and Console.
+//(the plaintext), and writes to File and Console. The file is replaced
+//atomically, keeping its permissions if it already exists. Nothing is
+//deleted, see CipherTextFile to encrypt a plaintext file.
 func CipherText(plaintext []byte, filepath string, singleLine, disableValidation bool) (err error) {
-	outputFilepath := "./cipher.txt"
-	fileMode := os.FileMode.Perm(0644)
-	cipherBytes := CipherBytes(plaintext, singleLine, disableValidation)
-	fmt.Println("-----BEGIN (ENCRYPTED DATA + DEK) STRING-----")
-	fmt.Printf("%s\n", cipherBytes)
-	fmt.Println("-----END (ENCRYPTED DATA + DEK) STRING-----")
-	ioutil.WriteFile(outputFilepath, cipherBytes, fileMode)
-	fmt.Printf("Encryption successful, ciphertext available at %s\n",
+	outputFilepath := filepath
+	fileMode := fileMode(outputFilepath, os.FileMode.Perm(0644))
+	cipherBytes, err := CipherBytes(plaintext, singleLine, disableValidation)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(statusOutput, "-----BEGIN (ENCRYPTED DATA + DEK) STRING-----")
+	fmt.Fprintf(statusOutput, "%s\n", cipherBytes)
+	fmt.Fprintln(statusOutput, "-----END (ENCRYPTED DATA + DEK) STRING-----")
+	if err = writeFileAtomic(outputFilepath, fileMode, func(w io.Writer) error {
+		_, err := w.Write(cipherBytes)
+		return err
+	}); err != nil {
+		return
+	}
+	fmt.Fprintf(statusOutput, "Encryption successful, ciphertext available at %s\n",
 		outputFilepath)
 	return
 }
 
 //CipherBytes uses 'defaultOptions' go-flags to encrypt plaintext bytes and
 //return ciphertext bytes
-func CipherBytes(plaintext []byte, singleLine, disableValidation bool) (cipherBytes []byte) {
+func CipherBytes(plaintext []byte, singleLine, disableValidation bool) (cipherBytes []byte, err error) {
 
 	kmsProvider, err := getKmsProvider(defaultOptions.KMSProvider)
-	check(err)
-	return CipherBytesFromPrimitives(plaintext, singleLine, disableValidation, defaultOptions.ProjectID,
-		defaultOptions.LocationID, defaultOptions.KeyRingID,
-		defaultOptions.CryptoKeyID, defaultOptions.KeyName, kmsProvider)
+	if err != nil {
+		return
+	}
+	return CipherBytesFromPrimitives(plaintext, singleLine, disableValidation,
+		kmsProvider, defaultOptions.AAD)
 }
 
-//CipherBytesFromPrimitives encrypts plaintext bytes and returns ciphertext bytes
+//CipherBytesFromPrimitives encrypts plaintext bytes, using a new DEK
+//encrypted by the KmsProvider, and returns ciphertext bytes. The AAD (which
+//may be nil) must be supplied again to decrypt them.
 func CipherBytesFromPrimitives(plaintext []byte, singleLine,
-	disableValidation bool,
-	projectID, locationID, keyRingID, cryptoKeyID, keyName string,
-	kmsProvider KmsProvider) (cipherBytes []byte) {
-
-	dek := randByteSlice(dekLength)
-	nonce := randByteSlice(nonceLength)
-	encrypt := true
-	encryptedDek, err := kmsProvider.crypto(dek, projectID, locationID, keyRingID,
-		cryptoKeyID, keyName, encrypt)
-	check(err)
-	cipherBytes = []byte(base64.StdEncoding.EncodeToString(append(
-		append(cipherText(plaintext, cipherblock(dek), nonce, encrypt),
-			nonce...),
-		encryptedDek...)))
+	disableValidation bool, kmsProvider KmsProvider, aad AAD) (cipherBytes []byte, err error) {
+
+	sealed, err := sealWithNewDek(plaintext, kmsProvider, aad)
+	if err != nil {
+		return
+	}
+	cipherBytes = []byte(base64.StdEncoding.EncodeToString(sealed))
 	if !singleLine {
 		cipherBytes = insertNewLines(cipherBytes)
 	}
-	if !disableValidation {
-		//validate the ciphertext
-		fmt.Println("Validating ciphertext")
-		cipherString, err := base64.StdEncoding.DecodeString(string(cipherBytes))
-		check(err)
-		_, err = PlainTextFromPrimitives(cipherString, projectID,
-			locationID, keyRingID, cryptoKeyID, keyName, kmsProvider)
-		check(err)
+	if validating(disableValidation, kmsProvider) {
+		err = validateCipherBytes(cipherBytes, kmsProvider, aad)
+	}
+	if err != nil {
+		cipherBytes = nil
 	}
 	return
 }
+
+//sealWithNewDek encrypts the plaintext with a newly generated DEK, returning
+//the header (including the KMS encrypted DEK) followed by the encrypted data
+func sealWithNewDek(plaintext []byte, kmsProvider KmsProvider,
+	aad AAD) (sealed []byte, err error) {
+
+	dek, err := randByteSlice(dekLength)
+	if err != nil {
+		return
+	}
+	nonce, err := randByteSlice(nonceLength)
+	if err != nil {
+		return
+	}
+	h, err := newHeader(kmsProvider, dek, nonce, aad)
+	if err != nil {
+		return
+	}
+	rawHeader, err := h.marshal()
+	if err != nil {
+		return
+	}
+	sealed, err = cipherText(plaintext, dek, nonce,
+		additionalData(rawHeader, aad), true)
+	return append(rawHeader, sealed...), err
+}
+
+//newHeader uses the KmsProvider to encrypt the DEK, and returns a header
+//recording it, along with any encryption context it was bound to, when it was
+//encrypted and the keys of the AAD
+func newHeader(kmsProvider KmsProvider, dek, nonce []byte,
+	aad AAD) (h header, err error) {
+	w, err := wrapDek(context.Background(), kmsProvider, dek)
+	return header{
+		version:           currentFormatVersion,
+		providerID:        w.providerID,
+		encryptedDek:      w.encryptedDek,
+		nonce:             nonce,
+		encryptionContext: w.encryptionContext,
+		keyID:             w.keyID,
+		created:           time.Now().Unix(),
+		aadKeys:           aad.keys(),
+	}, err
+}
+
+//validating reports whether to validate the ciphertext by decrypting it,
+//unless validation is disabled, or the KmsProvider can't decrypt, e.g. age
+//with no identity, which is noted on stderr
+func validating(disableValidation bool, kmsProvider KmsProvider) bool {
+	if disableValidation {
+		return false
+	}
+	if !canDecrypt(kmsProvider) {
+		fmt.Fprintf(os.Stderr, "Validation skipped, KMS Provider %s has no key "+
+			"to decrypt with, e.g. an age identity\n", kmsProvider.Name())
+		return false
+	}
+	fmt.Fprintln(statusOutput, "Validating ciphertext")
+	return true
+}
+
+//validateCipherBytes checks the base64 encoded ciphertext can be decrypted
+func validateCipherBytes(cipherBytes []byte, kmsProvider KmsProvider,
+	aad AAD) error {
+
+	cipherString, err := base64.StdEncoding.DecodeString(string(cipherBytes))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	_, err = PlainTextFromPrimitives(cipherString, kmsProvider, aad)
+	return err
+}
+
+//CipherTextFile encrypts the plaintext file ("-" for stdin), using
+//'defaultOptions' go-flags, and writes the ciphertext to the output file ("-"
+//for stdout). Unless retainPlainText is set, the plaintext file is then
+//zerofilled and deleted.
+func CipherTextFile(filepath, outputFilepath string, singleLine,
+	disableValidation, retainPlainText bool) (err error) {
+	if sameFilepath(filepath, outputFilepath) {
+		return errors.New("filepath and targetFilepath must be different files")
+	}
+	encrypt := cipherTextFile
+	if defaultOptions.Format != "" {
+		encrypt = structuredCipherTextFile
+	}
+	if err = encrypt(filepath, outputFilepath, singleLine,
+		disableValidation); err != nil || retainPlainText || filepath == stdioFilepath {
+		return
+	}
+	return secureDelete(filepath, false)
+}
+
+//cipherTextFile encrypts the plaintext file, streaming it when it's read from
+//stdin, the ciphertext is written to stdout, or it's larger than the
+//StreamingThreshold
+func cipherTextFile(filepath, outputFilepath string, singleLine,
+	disableValidation bool) error {
+	stream := filepath == stdioFilepath || outputFilepath == stdioFilepath
+	if fileInfo, err := os.Stat(filepath); !stream && err == nil {
+		stream = fileInfo.Size() > StreamingThreshold
+	}
+	if stream {
+		return streamCipherTextFile(filepath, outputFilepath, singleLine,
+			disableValidation)
+	}
+	dat, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	return CipherText(dat, outputFilepath, singleLine, disableValidation)
+}
+
+//structuredCipherTextFile encrypts the values of the structured plaintext
+//file, see EncryptStructured, using 'defaultOptions' go-flags for the format
+//and key regex
+func structuredCipherTextFile(filepath, outputFilepath string, singleLine,
+	disableValidation bool) error {
+	plaintext, err := readInput(filepath)
+	if err != nil {
+		return err
+	}
+	kmsProvider, err := getKmsProvider(defaultOptions.KMSProvider)
+	if err != nil {
+		return err
+	}
+	return writeStructured(outputFilepath, plaintext, defaultOptions.KeyRegex,
+		disableValidation, kmsProvider)
+}
+
+//writeStructured encrypts the values of the structured plaintext with the
+//KmsProvider, and writes it to the output file ("-" for stdout)
+func writeStructured(outputFilepath string, plaintext []byte, keyRegex string,
+	disableValidation bool, kmsProvider KmsProvider) error {
+	encrypted, err := EncryptStructured(plaintext, defaultOptions.Format,
+		keyRegex, kmsProvider, defaultOptions.AAD)
+	if err != nil {
+		return err
+	}
+	if err = validateStructured(encrypted, disableValidation,
+		kmsProvider); err != nil {
+		return err
+	}
+	if err = writeOutput(outputFilepath, encrypted); err == nil &&
+		outputFilepath != stdioFilepath {
+		fmt.Fprintf(statusOutput, "Encryption successful, ciphertext available at %s\n",
+			outputFilepath)
+	}
+	return err
+}
+
+//validateStructured checks the values of the encrypted structured file can
+//be decrypted, unless validation is disabled
+func validateStructured(encrypted []byte, disableValidation bool,
+	kmsProvider KmsProvider) error {
+	if !validating(disableValidation, kmsProvider) {
+		return nil
+	}
+	_, err := DecryptStructured(encrypted, defaultOptions.Format, kmsProvider,
+		defaultOptions.AAD)
+	return err
+}
+
+//streamCipherTextFile streams the plaintext file through EncryptStream
+func streamCipherTextFile(filepath, outputFilepath string, singleLine,
+	disableValidation bool) (err error) {
+	kmsProvider, err := getKmsProvider(defaultOptions.KMSProvider)
+	if err != nil {
+		return
+	}
+	if err = encryptFile(filepath, outputFilepath, singleLine, disableValidation,
+		kmsProvider); err != nil {
+		return
+	}
+	if outputFilepath != stdioFilepath {
+		fmt.Fprintf(statusOutput, "Encryption successful, ciphertext available at %s\n",
+			outputFilepath)
+	}
+	return
+}
+
+//encryptFile streams the plaintext file through EncryptStream into the output
+//file, which is replaced atomically
+func encryptFile(filepath, outputFilepath string, singleLine,
+	disableValidation bool, kmsProvider KmsProvider) (err error) {
+	input, err := openInput(filepath)
+	if err != nil {
+		return
+	}
+	defer input.Close()
+	encrypt := func(w io.Writer) error {
+		return encryptAndValidate(w, input, singleLine, disableValidation,
+			kmsProvider, defaultOptions.AAD)
+	}
+	if outputFilepath == stdioFilepath {
+		return encrypt(os.Stdout)
+	}
+	return writeFileAtomic(outputFilepath, fileMode(outputFilepath, 0644), encrypt)
+}
+
+//encryptAndValidate encrypts plaintext read from r using EncryptStream,
+//writing the ciphertext to w. Unless validation is disabled, the ciphertext is
+//decrypted as it's written, to validate it without reading it back.
+func encryptAndValidate(w io.Writer, r io.Reader, singleLine,
+	disableValidation bool, kmsProvider KmsProvider, aad AAD) error {
+	if !validating(disableValidation, kmsProvider) {
+		return EncryptStream(w, r, singleLine, kmsProvider, aad)
+	}
+	validationReader, validationWriter := io.Pipe()
+	validated := make(chan error, 1)
+	go func() {
+		err := DecryptStream(ioutil.Discard, validationReader, kmsProvider, aad)
+		validationReader.CloseWithError(err)
+		validated <- err
+	}()
+	err := EncryptStream(io.MultiWriter(w, validationWriter), r, singleLine,
+		kmsProvider, aad)
+	validationWriter.CloseWithError(err)
+	if validationErr := <-validated; err == nil {
+		err = validationErr
+	}
+	return err
+}
+
+//EncryptStream encrypts plaintext read from r in chunks, using a new DEK
+//encrypted by the KmsProvider, and writes the base64 encoded ciphertext to w.
+//The AAD (which may be nil) must be supplied again to decrypt it.
+func EncryptStream(w io.Writer, r io.Reader, singleLine bool,
+	kmsProvider KmsProvider, aad AAD) (err error) {
+	if !singleLine {
+		w = &newLineWriter{w: w}
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	encryptWriter, err := NewEncryptWriter(encoder, kmsProvider, aad)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(encryptWriter, r); err != nil {
+		return
+	}
+	if err = encryptWriter.Close(); err != nil {
+		return
+	}
+	return encoder.Close()
+}
@@ -1,6 +1,13 @@
 package crypt
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -21,3 +28,69 @@ func TestInsertNewLines(t *testing.T) {
 		}
 	}
 }
+
+func TestNewLineWriter(t *testing.T) {
+	text := []byte(strings.Repeat("a", 135))
+	for _, pieceLength := range []int{1, 7, 40, 41, 200} {
+		var buf bytes.Buffer
+		w := &newLineWriter{w: &buf}
+		for i := 0; i < len(text); i += pieceLength {
+			end := i + pieceLength
+			if end > len(text) {
+				end = len(text)
+			}
+			w.Write(text[i:end])
+		}
+		if buf.String() != string(insertNewLines(text)) {
+			t.Errorf("Writing %d bytes at a time got %q", pieceLength, buf.String())
+		}
+	}
+}
+
+//failingDecryptKms can encrypt, but fails to decrypt
+type failingDecryptKms struct {
+	stubKms
+}
+
+func (f failingDecryptKms) Decrypt(ctx context.Context, encryptedDek []byte) ([]byte, error) {
+	return nil, kmsError(f.Name(), false, errors.New("unavailable"))
+}
+
+func TestEncryptAndValidate(t *testing.T) {
+	plaintext, _ := randByteSlice(3 * defaultChunkSize)
+	var buf bytes.Buffer
+	err := encryptAndValidate(&buf, bytes.NewReader(plaintext), false, false,
+		stubKms{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encryptAndValidate(&buf, bytes.NewReader(plaintext), false, false,
+		failingDecryptKms{}, nil)
+	if !errors.Is(err, ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v", err, ErrKMSUnavailable)
+	}
+}
+
+func TestCipherTextFileRetainPlainText(t *testing.T) {
+	useStubProvider(t)
+	dir, err := ioutil.TempDir("", "mantle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plainPath := filepath.Join(dir, "plain.txt")
+	cipherPath := filepath.Join(dir, "cipher.txt")
+	for _, retainPlainText := range []bool{true, false} {
+		ioutil.WriteFile(plainPath, []byte("helloworld"), 0644)
+		err = CipherTextFile(plainPath, cipherPath, false, false, retainPlainText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(plainPath); (err == nil) != retainPlainText {
+			t.Errorf("Plaintext file exists: %v, want %v", err == nil, retainPlainText)
+		}
+	}
+	if err = CipherTextFile(cipherPath, cipherPath, false, false, true); err == nil {
+		t.Error("Expected error encrypting a file to itself")
+	}
+}
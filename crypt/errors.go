@@ -0,0 +1,78 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrCiphertextTooShort is returned when a ciphertext is shorter than the
+	//smallest ciphertext that could have been generated
+	ErrCiphertextTooShort = errors.New("ciphertext too short")
+	//ErrInvalidCiphertext is returned when a ciphertext can't be decoded or
+	//its header is malformed
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	//ErrUnsupportedVersion is returned when a ciphertext was written in a
+	//format version this release doesn't understand
+	ErrUnsupportedVersion = errors.New("unsupported ciphertext format version")
+	//ErrAuthenticationFailed is returned when the encrypted data fails GCM
+	//authentication, i.e. it's been tampered with or the wrong DEK was used
+	ErrAuthenticationFailed = errors.New("ciphertext authentication failed")
+	//ErrKMSUnavailable is matched by every KMSError, i.e. any failed request
+	//to a KMS provider
+	ErrKMSUnavailable = errors.New("KMS request failed")
+	//ErrUnsupportedProvider is returned for an unknown KMS provider name
+	ErrUnsupportedProvider = errors.New("KMS provider not supported")
+	//ErrProviderMismatch is returned when a ciphertext was encrypted using a
+	//different KMS provider to the one decrypting it
+	ErrProviderMismatch = errors.New("ciphertext encrypted with a different KMS provider")
+)
+
+//KMSError records a failed request to a KMS provider, and the underlying
+//error returned by it
+type KMSError struct {
+	Provider string
+	Encrypt  bool
+	Err      error
+}
+
+func (e *KMSError) Error() string {
+	operation := "decrypt"
+	if e.Encrypt {
+		operation = "encrypt"
+	}
+	return fmt.Sprintf("%s KMS failed to %s DEK: %v", e.Provider, operation,
+		e.Err)
+}
+
+//Unwrap returns the underlying error returned by the KMS provider
+func (e *KMSError) Unwrap() error {
+	return e.Err
+}
+
+//Is reports whether the target is ErrKMSUnavailable
+func (e *KMSError) Is(target error) bool {
+	return target == ErrKMSUnavailable
+}
+
+//kmsError wraps a non-nil error returned by a KMS provider in a KMSError
+func kmsError(provider string, encrypt bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &KMSError{Provider: provider, Encrypt: encrypt, Err: err}
+}
@@ -0,0 +1,135 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+func init() {
+	Parser.AddCommand("exec",
+		"Runs a command with decrypted secrets in its environment",
+		"Decrypts a dotenv formatted ciphertext in memory, and runs the command "+
+			"given after -- with its variables added to the environment. Signals "+
+			"are forwarded to the command, and its exit code is returned. The "+
+			"plaintext is never written to disk.",
+		&execCommand)
+}
+
+//ExecCommand type
+type ExecCommand struct {
+	Filepath string `short:"f" long:"filepath" description:"Path of file to get encrypted dotenv from, or - for stdin" default:"./cipher.txt"`
+}
+
+var execCommand ExecCommand
+
+//Execute executes the ExecCommand, exiting with the command's exit code
+func (x *ExecCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("a command to run is required, e.g. " +
+			"mantle exec -f secrets.env.enc -- ./server")
+	}
+	kmsProvider, err := getDecryptKmsProvider()
+	if err != nil {
+		return err
+	}
+	env, err := decryptEnv(x.Filepath, kmsProvider)
+	if err != nil {
+		return err
+	}
+	exitCode, err := runCommand(args, env)
+	if err != nil {
+		return err
+	}
+	os.Exit(exitCode)
+	return nil
+}
+
+//decryptEnv decrypts the dotenv formatted ciphertext file in memory, and
+//returns the current environment with its variables added (replacing any of
+//the same name)
+func decryptEnv(filepath string, kmsProvider KmsProvider) ([]string, error) {
+	var plaintext bytes.Buffer
+	defer func() {
+		zeroBytes(plaintext.Bytes())
+	}()
+	if err := decryptFile(filepath, &plaintext, kmsProvider); err != nil {
+		return nil, err
+	}
+	vars, err := parseDotenv(plaintext.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	env := os.Environ()
+	for _, v := range vars {
+		env = append(env, v.String())
+	}
+	return env, nil
+}
+
+//zeroBytes overwrites the slice with zeros, so plaintext doesn't linger in
+//memory
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+//runCommand runs the command with the environment, attached to mantle's
+//stdin, stdout and stderr, and forwarding signals to it until it exits. The
+//exit code is the command's, or 128 plus the signal number if a signal
+//killed it (as in a shell).
+func runCommand(args, env []string) (int, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	signals := make(chan os.Signal, len(forwardedSignals))
+	signal.Notify(signals, forwardedSignals...)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	go forwardSignals(cmd.Process, signals)
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitStatus(exitErr.ProcessState), nil
+	}
+	return 0, err
+}
+
+//forwardSignals sends every signal received to the process, until the
+//channel is closed
+func forwardSignals(process *os.Process, signals <-chan os.Signal) {
+	for sig := range signals {
+		process.Signal(sig)
+	}
+}
+
+//exitStatus returns the exit code of the exited process
+func exitStatus(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
@@ -0,0 +1,83 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package crypt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDecryptEnv(t *testing.T) {
+	cipherBytes, err := CipherBytesFromPrimitives([]byte("SECRET=value\n"),
+		false, true, stubKms{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherPath := filepath.Join(t.TempDir(), "secrets.env.enc")
+	if err = ioutil.WriteFile(cipherPath, cipherBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	env, err := decryptEnv(cipherPath, stubKms{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env[len(env)-1] != "SECRET=value" || len(env) != len(os.Environ())+1 {
+		t.Errorf("Got %q, want the environment with SECRET=value", env)
+	}
+}
+
+var runCommandTests = []struct {
+	script string
+	want   int
+}{
+	{`test "$SECRET" = value`, 0},
+	{"exit 3", 3},
+	{"kill -TERM $$", 128 + int(syscall.SIGTERM)},
+}
+
+func TestRunCommand(t *testing.T) {
+	for _, test := range runCommandTests {
+		exitCode, err := runCommand([]string{"sh", "-c", test.script},
+			[]string{"SECRET=value"})
+		if err != nil || exitCode != test.want {
+			t.Errorf("%s: got %d (%v), want %d", test.script, exitCode, err,
+				test.want)
+		}
+	}
+}
+
+func TestRunCommandForwardsSignals(t *testing.T) {
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	}()
+	exitCode, err := runCommand([]string{"sh", "-c",
+		"trap 'exit 7' USR1; while :; do sleep 0.1; done"}, nil)
+	if err != nil || exitCode != 7 {
+		t.Errorf("Got %d (%v), want the trap's exit code 7", exitCode, err)
+	}
+}
+
+func TestRunCommandNotFound(t *testing.T) {
+	if _, err := runCommand([]string{"mantle-no-such-command"}, nil); err == nil {
+		t.Error("Expected error running a missing command")
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import "os"
+
+//forwardedSignals are caught by exec while the command it runs is running.
+//Windows can't send Ctrl+C to another process, but the console sends it to the
+//command too, so catching it just stops mantle exiting first.
+var forwardedSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,335 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//documentFormat parses a structured file as a YAML document (so the order of
+//keys, and any comments, are kept), returning it along with a func that
+//formats the document again, once its values have been changed
+type documentFormat func(data []byte) (doc *yaml.Node,
+	marshal func() ([]byte, error), err error)
+
+//documentFormats are the structured file formats, by --format name
+var documentFormats = map[string]documentFormat{
+	"dotenv": parseDotenvDocument,
+	"json":   parseJSONDocument,
+	"yaml":   parseYAMLDocument,
+}
+
+//parseDocument parses the data in the named format, checking it's a mapping
+//at the top level
+func parseDocument(data []byte, format string) (doc *yaml.Node,
+	marshal func() ([]byte, error), err error) {
+	documentFormat, ok := documentFormats[strings.ToLower(format)]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported format %q, expected one of %s",
+			format, documentFormatNames())
+	}
+	doc, marshal, err = documentFormat(data)
+	if err == nil && doc.Content[0].Kind != yaml.MappingNode {
+		err = fmt.Errorf("%s document must be a mapping at the top level", format)
+	}
+	return
+}
+
+//documentFormatNames returns the --format names, e.g. json or yaml
+func documentFormatNames() string {
+	names := make([]string, 0, len(documentFormats))
+	for name := range documentFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names[:len(names)-1], ", ") + " or " +
+		names[len(names)-1]
+}
+
+//dotenvMetadataPrefix prefixes the names of the variables the metadata is
+//recorded in, as a dotenv file can't nest it
+const dotenvMetadataPrefix = metadataKey + "_"
+
+func parseYAMLDocument(data []byte) (*yaml.Node, func() ([]byte, error),
+	error) {
+	doc, err := parseYAML(data)
+	return doc, func() ([]byte, error) {
+		return marshalYAML(doc)
+	}, err
+}
+
+func parseJSONDocument(data []byte) (*yaml.Node, func() ([]byte, error),
+	error) {
+	doc, err := parseJSON(data)
+	return doc, func() ([]byte, error) {
+		return marshalJSON(doc)
+	}, err
+}
+
+//parseYAML parses a single YAML document
+func parseYAML(data []byte) (*yaml.Node, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var doc, extra yaml.Node
+	if err := decoder.Decode(&doc); err == io.EOF {
+		return nil, errors.New("yaml document is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid yaml: %w", err)
+	}
+	if err := decoder.Decode(&extra); err != io.EOF {
+		return nil, errors.New("yaml file must contain a single document")
+	}
+	return &doc, nil
+}
+
+//marshalYAML formats the YAML document, indented by 2 spaces
+func marshalYAML(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	err := encoder.Close()
+	return buf.Bytes(), err
+}
+
+//parseJSON parses a JSON document into YAML nodes, keeping the order of keys
+func parseJSON(data []byte) (*yaml.Node, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	root, err := readJSONValue(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid json: more than one top-level value")
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}, nil
+}
+
+//readJSONValue reads the next value
+func readJSONValue(decoder *json.Decoder) (*yaml.Node, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		return readJSONObject(decoder)
+	case json.Delim('['):
+		return readJSONArray(decoder)
+	}
+	return jsonScalarNode(token), nil
+}
+
+func readJSONObject(decoder *json.Decoder) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		value, err := readJSONValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, stringNode(key.(string)), value)
+	}
+	_, err := decoder.Token()
+	return node, err
+}
+
+func readJSONArray(decoder *json.Decoder) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode}
+	for decoder.More() {
+		value, err := readJSONValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, value)
+	}
+	_, err := decoder.Token()
+	return node, err
+}
+
+//jsonScalarNode returns the node for a string, number, boolean or null
+func jsonScalarNode(token json.Token) *yaml.Node {
+	switch value := token.(type) {
+	case string:
+		return stringNode(value)
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(string(value), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: string(value)}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool",
+			Value: strconv.FormatBool(value)}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: nullTag, Value: "null"}
+}
+
+//marshalJSON formats the document as JSON, indented by 2 spaces
+func marshalJSON(doc *yaml.Node) ([]byte, error) {
+	var compact, indented bytes.Buffer
+	if err := writeJSON(&compact, doc.Content[0]); err != nil {
+		return nil, err
+	}
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+//writeJSON writes the node as compact JSON
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return writeJSONObject(buf, node)
+	case yaml.SequenceNode:
+		return writeJSONArray(buf, node)
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias)
+	}
+	return writeJSONScalar(buf, node)
+}
+
+func writeJSONObject(buf *bytes.Buffer, node *yaml.Node) error {
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, node.Content[i].Value)
+		buf.WriteByte(':')
+		if err := writeJSON(buf, node.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeJSONArray(buf *bytes.Buffer, node *yaml.Node) error {
+	buf.WriteByte('[')
+	for i, child := range node.Content {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSON(buf, child); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+//writeJSONScalar writes numbers, booleans and null as they are, and anything
+//else as a string
+func writeJSONScalar(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.ShortTag() {
+	case "!!int", "!!float", "!!bool":
+		if !json.Valid([]byte(node.Value)) {
+			return fmt.Errorf("%s value isn't valid json", node.ShortTag())
+		}
+		buf.WriteString(node.Value)
+	case nullTag:
+		buf.WriteString("null")
+	default:
+		writeJSONString(buf, node.Value)
+	}
+	return nil
+}
+
+//writeJSONString writes the string quoted, without escaping HTML characters
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	buf.Truncate(buf.Len() - 1)
+}
+
+//parseDotenvDocument parses a dotenv file as a mapping of names to string
+//values, gathering any metadata variables into the metadata mapping. The
+//layout of each variable, and its comments, are kept, so it's formatted the
+//same way again.
+func parseDotenvDocument(data []byte) (*yaml.Node, func() ([]byte, error),
+	error) {
+	vars, layouts, trailing, err := parseDotenvLayout(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	metadata := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	valueLayouts := map[*yaml.Node]dotenvLayout{}
+	for i, v := range vars {
+		if key := strings.TrimPrefix(v.name, dotenvMetadataPrefix); key != v.name {
+			addMetadataValue(metadata, key, v.value)
+			continue
+		}
+		value := stringNode(v.value)
+		valueLayouts[value] = layouts[i]
+		root.Content = append(root.Content, stringNode(v.name), value)
+	}
+	if len(metadata.Content) > 0 {
+		root.Content = append(root.Content, stringNode(metadataKey), metadata)
+	}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	return doc, func() ([]byte, error) {
+		return formatDotenv(root, valueLayouts, trailing), nil
+	}, nil
+}
+
+//formatDotenv formats the mapping as a dotenv file, laying out each variable
+//as it was parsed, and the metadata as variables prefixed with mantle_
+func formatDotenv(root *yaml.Node, valueLayouts map[*yaml.Node]dotenvLayout,
+	trailing string) []byte {
+	var buf bytes.Buffer
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name, value := root.Content[i].Value, root.Content[i+1]
+		if name == metadataKey && value.Kind == yaml.MappingNode {
+			formatDotenvMetadata(&buf, value)
+			continue
+		}
+		layout, ok := valueLayouts[value]
+		if !ok {
+			layout = dotenvLayout{prefix: name + "="}
+		}
+		buf.WriteString(layout.format(value.Value))
+	}
+	buf.WriteString(trailing)
+	return buf.Bytes()
+}
+
+//formatDotenvMetadata formats the metadata mapping as variables prefixed with
+//mantle_
+func formatDotenvMetadata(buf *bytes.Buffer, metadata *yaml.Node) {
+	for i := 0; i+1 < len(metadata.Content); i += 2 {
+		layout := dotenvLayout{prefix: dotenvMetadataPrefix +
+			metadata.Content[i].Value + "="}
+		buf.WriteString(layout.format(metadata.Content[i+1].Value))
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+var invalidDocumentTests = []struct {
+	format string
+	data   string
+}{
+	{"toml", "a = 1"},
+	{"yaml", ""},
+	{"yaml", "a: [1"},
+	{"yaml", "a: 1\n---\nb: 2"},
+	{"yaml", "- a"},
+	{"json", `{"a": 1`},
+	{"json", `{"a": 1} {}`},
+	{"json", `[1]`},
+	{"dotenv", "A=1\nB"},
+}
+
+func TestParseInvalidDocument(t *testing.T) {
+	for _, test := range invalidDocumentTests {
+		if _, _, err := parseDocument([]byte(test.data), test.format); err == nil {
+			t.Errorf("Expected error parsing %s %q", test.format, test.data)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	data := "{\n  \"z\": [\n    1.5e3,\n    true,\n    null,\n    {}\n  ],\n  \"a\": \"\\u0001\"\n}\n"
+	_, marshal, err := parseDocument([]byte(data), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	marshalled, err := marshal()
+	if err != nil || string(marshalled) != data {
+		t.Errorf("Got %s (%v), want %s", marshalled, err, data)
+	}
+}
+
+func TestDotenvRoundTrip(t *testing.T) {
+	data := "# db\n\nexport DB_USER = admin # user\nA='b c'\r\n" +
+		"PEM=\"x\ny\" # pem\n\n# end\n"
+	_, marshal, err := parseDocument([]byte(data), "dotenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(data, "\r", "", 1)
+	if marshalled, err := marshal(); err != nil || string(marshalled) != want {
+		t.Errorf("Got %q (%v), want %q", marshalled, err, want)
+	}
+}
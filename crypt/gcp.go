@@ -4,77 +4,126 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"regexp"
+	"strings"
 
-	"golang.org/x/oauth2/google"
 	cloudkms "google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
 )
 
+func init() {
+	mustRegisterProvider("GCP", newGcpKms)
+}
+
 // GcpKms type
-type GcpKms struct{}
+type GcpKms struct {
+	//KeyName is the key's resource ID, in the form
+	//projects/<project>/locations/<location>/keyRings/<keyRing>/cryptoKeys/<key>
+	KeyName string
+	//Endpoint overrides the KMS endpoint URL, e.g. for a local stand-in.
+	//Requests to plain http endpoints aren't authenticated.
+	Endpoint string
+}
+
+//newGcpKms creates a GcpKms from the input flags, using the keyName if set, or
+//building the resource ID from the projectId, locationId, keyringId and
+//cryptokeyId otherwise
+func newGcpKms(opts Defaults) (KmsProvider, error) {
+	g := GcpKms{KeyName: opts.KeyName,
+		Endpoint: kmsEndpoint(opts.GcpKMSEndpoint, opts)}
+	if len(g.KeyName) == 0 {
+		g.KeyName = fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s",
+			opts.ProjectID, opts.LocationID, opts.KeyRingID, opts.CryptoKeyID)
+	}
+	return g, nil
+}
 
 func (g GcpKms) encryptedDekLength() int {
 	return 114
 }
 
-// uses google kms to either encrypt or decrypt a byte slice
-func (g GcpKms) crypto(payload []byte, projectid, locationid, keyringid,
-	cryptokeyid, keyname string, encrypt bool) (resultText []byte, err error) {
-	kmsService := kmsClient()
-	var parentName string
-	if len(keyname) > 0 {
-		parentName = keyname
-	} else {
-		parentName = fmt.Sprintf(
-			"projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", projectid,
-			locationid, keyringid, cryptokeyid)
+func (g GcpKms) keyID() string {
+	return g.KeyName
+}
+
+//gcpKeyNamePattern matches key resource IDs, without . or .. segments that
+//would change the request path
+var gcpKeyNamePattern = regexp.MustCompile(`^projects/[\w:-][\w:.-]*/locations/[\w-]+/` +
+	`keyRings/[\w-]+/cryptoKeys/[\w-]+$`)
+
+//checkKeyID checks the KeyName is a key resource ID
+func (g GcpKms) checkKeyID() error {
+	if !gcpKeyNamePattern.MatchString(g.KeyName) {
+		return fmt.Errorf("gcp key %q isn't of the form "+
+			"projects/<project>/locations/<location>/keyRings/<keyRing>/cryptoKeys/<key>",
+			g.KeyName)
 	}
-	if encrypt {
-		resultText, err = googleKMSEncrypt(payload, parentName, kmsService)
-	} else {
-		resultText, err = googleKMSDecrypt(payload, parentName, kmsService)
+	return nil
+}
+
+//Name returns "GCP"
+func (g GcpKms) Name() string {
+	return "GCP"
+}
+
+//Encrypt uses google kms to encrypt the DEK
+func (g GcpKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte, err error) {
+	kmsService, err := kmsClient(ctx, g.Endpoint)
+	if err == nil {
+		encryptedDek, err = googleKMSEncrypt(ctx, dek, g.KeyName, kmsService)
+	}
+	return encryptedDek, kmsError(g.Name(), true, err)
+}
+
+//Decrypt uses google kms to decrypt the DEK
+func (g GcpKms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte, err error) {
+	kmsService, err := kmsClient(ctx, g.Endpoint)
+	if err == nil {
+		dek, err = googleKMSDecrypt(ctx, encryptedDek, g.KeyName, kmsService)
 	}
-	return
+	return dek, kmsError(g.Name(), false, err)
 }
 
 //googleKMSEncrypt uses google kms to encypt a bite slice
-func googleKMSEncrypt(payload []byte, parentName string,
+func googleKMSEncrypt(ctx context.Context, payload []byte, parentName string,
 	kmsService *cloudkms.Service) (resultText []byte, err error) {
 	req := &cloudkms.EncryptRequest{
 		Plaintext: base64.StdEncoding.EncodeToString(payload),
 	}
 	var resp *cloudkms.EncryptResponse
-	resp, err = kmsService.Projects.Locations.KeyRings.CryptoKeys.
-		Encrypt(parentName, req).Do()
-	check(err)
-	var errm error
-	resultText, errm = base64.StdEncoding.DecodeString(resp.Ciphertext)
-	check(errm)
-	return
+	if resp, err = kmsService.Projects.Locations.KeyRings.CryptoKeys.
+		Encrypt(parentName, req).Context(ctx).Do(); err != nil {
+		return
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
 }
 
 //googleKMSDecrypt uses google kms to decypt a bite slice
-func googleKMSDecrypt(payload []byte, parentName string,
+func googleKMSDecrypt(ctx context.Context, payload []byte, parentName string,
 	kmsService *cloudkms.Service) (resultText []byte, err error) {
 	req := &cloudkms.DecryptRequest{
 		Ciphertext: base64.StdEncoding.EncodeToString(payload),
 	}
 	var resp *cloudkms.DecryptResponse
 	if resp, err = kmsService.Projects.Locations.KeyRings.CryptoKeys.
-		Decrypt(parentName, req).Do(); err != nil {
+		Decrypt(parentName, req).Context(ctx).Do(); err != nil {
 		return
 	}
-	var errm error
-	resultText, errm = base64.StdEncoding.DecodeString(resp.Plaintext)
-	check(errm)
-	return
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
 }
 
-//kmsClient returns a kms service created from a default google client
-func kmsClient() (kmsService *cloudkms.Service) {
-	ctx := context.Background()
-	client, errc := google.DefaultClient(ctx, cloudkms.CloudPlatformScope)
-	check(errc)
-	kmsService, errk := cloudkms.New(client)
-	check(errk)
-	return
+//kmsClient returns a kms service using the default google credentials, and
+//the endpoint if it's set
+func kmsClient(ctx context.Context, endpoint string) (*cloudkms.Service, error) {
+	opts := []option.ClientOption{option.WithScopes(cloudkms.CloudPlatformScope)}
+	if endpoint != "" {
+		if !strings.HasSuffix(endpoint, "/") {
+			endpoint += "/"
+		}
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	if strings.HasPrefix(endpoint, "http://") {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	return cloudkms.NewService(ctx, opts...)
 }
@@ -0,0 +1,429 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+func init() {
+	Parser.AddCommand("git-filter",
+		"Encrypts or decrypts a file as git's clean or smudge filter",
+		"Run by git once set up by git-init. clean encrypts the plaintext read "+
+			"from stdin, reusing the ciphertext in the index if the plaintext "+
+			"hasn't changed. smudge decrypts the ciphertext read from stdin, "+
+			"leaving it as it is if it can't be decrypted, e.g. without access "+
+			"to the KMS key. Both write to stdout.",
+		&gitFilterCommand)
+	Parser.AddCommand("git-textconv",
+		"Decrypts a file for git diff",
+		"Run by git once set up by git-init. Decrypts the file so that git "+
+			"diff shows the plaintext, leaving it as it is if it can't be "+
+			"decrypted.",
+		&gitTextconvCommand)
+	Parser.AddCommand("git-init",
+		"Sets up git to encrypt the files matching the patterns",
+		"Adds the patterns to .gitattributes, and configures the repository's "+
+			"mantle filter and diff driver, with the options given before "+
+			"git-init. Matching files are then encrypted when they're "+
+			"committed, decrypted when they're checked out, and diffed as "+
+			"plaintext.",
+		&gitInitCommand)
+}
+
+//GitFilterCommand type
+type GitFilterCommand struct{}
+
+//GitTextconvCommand type
+type GitTextconvCommand struct{}
+
+//GitInitCommand type
+type GitInitCommand struct{}
+
+var (
+	gitFilterCommand   GitFilterCommand
+	gitTextconvCommand GitTextconvCommand
+	gitInitCommand     GitInitCommand
+)
+
+//gitAttributes are given to the files matching the git-init patterns
+const gitAttributes = "filter=mantle diff=mantle"
+
+//gitFilterFlags are the long names of the flags git-init stores in the git
+//config for the filters to use, as they aren't secrets. The config is kept in
+//plain text in .git, so secrets, e.g. --pkcs11Pin, are read from the
+//environment by the filters instead.
+var gitFilterFlags = map[string]bool{
+	"kmsProvider": true, "keyName": true, "cryptokeyId": true, "keyringId": true,
+	"locationId": true, "projectId": true, "key": true, "threshold": true,
+	"kmsEndpoint": true, "awsKmsEndpoint": true, "gcpKmsEndpoint": true,
+	"aad": true, "format": true, "keyRegex": true,
+	"awsRegion": true, "awsProfile": true, "awsRoleArn": true,
+	"vaultNamespace": true, "vaultTransitMount": true,
+	"vaultKubernetesRole": true, "ageRecipient": true, "ageRecipientsFile": true,
+	"ageIdentityFile": true, "pkcs11Module": true, "pkcs11Slot": true,
+	"localKeyFile": true, "encryptionContext": true,
+}
+
+//Execute executes the GitFilterCommand
+func (x *GitFilterCommand) Execute(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("expected clean or smudge, and the path of the file")
+	}
+	statusOutput = ioutil.Discard
+	switch args[0] {
+	case "clean":
+		return gitClean(os.Stdout, os.Stdin, append(args, "")[1])
+	case "smudge":
+		return gitSmudge(os.Stdout, os.Stdin)
+	}
+	return fmt.Errorf("unknown git filter %q, expected clean or smudge",
+		args[0])
+}
+
+//Execute executes the GitTextconvCommand
+func (x *GitTextconvCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the path of the file to decrypt")
+	}
+	statusOutput = ioutil.Discard
+	ciphertext, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	return writeDecrypted(os.Stdout, ciphertext)
+}
+
+//Execute executes the GitInitCommand
+func (x *GitInitCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected the patterns of the files to encrypt, " +
+			"e.g. cipher.txt or 'secrets/*.env'")
+	}
+	options, err := gitFilterOptions(os.Args[1:], args)
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err = configureGit(append([]string{executable}, options...)); err != nil {
+		return err
+	}
+	if err = addGitAttributes(args); err == nil {
+		fmt.Fprintf(statusOutput, "Files matching %s are now encrypted by git\n",
+			strings.Join(args, " "))
+	}
+	return err
+}
+
+//gitClean encrypts the plaintext read from r, writing the ciphertext to w. If
+//the plaintext is the same as that of the file in git's index, the indexed
+//ciphertext is written instead, so a file doesn't change each time it's
+//cleaned. A ciphertext, e.g. that smudge couldn't decrypt, is written as it
+//is.
+func gitClean(w io.Writer, r io.Reader, path string) error {
+	plaintext, err := ioutil.ReadAll(r)
+	defer zeroBytes(plaintext)
+	if err != nil {
+		return err
+	}
+	indexed := gitIndexedFile(path)
+	if isUnchanged(plaintext, indexed) {
+		_, err = w.Write(indexed)
+		return err
+	}
+	if isEncrypted(plaintext) {
+		_, err = w.Write(plaintext)
+		return err
+	}
+	return gitEncrypt(w, plaintext, indexed)
+}
+
+//gitIndexedFile returns the contents of the file in git's index, or nil if
+//it isn't there
+func gitIndexedFile(path string) []byte {
+	if path == "" {
+		return nil
+	}
+	indexed, err := exec.Command("git", "cat-file", "blob", ":"+path).Output()
+	if err != nil {
+		return nil
+	}
+	return indexed
+}
+
+//isUnchanged reports whether the plaintext is the same as the indexed
+//ciphertext, or its plaintext
+func isUnchanged(plaintext, indexed []byte) bool {
+	if len(indexed) == 0 {
+		return false
+	}
+	if bytes.Equal(plaintext, indexed) {
+		return true
+	}
+	decrypted, err := decryptBytes(indexed)
+	defer zeroBytes(decrypted)
+	return err == nil && bytes.Equal(plaintext, decrypted)
+}
+
+//isEncrypted reports whether the data is a ciphertext with a header, or an
+//encrypted structured file with --format
+func isEncrypted(data []byte) bool {
+	if defaultOptions.Format != "" {
+		_, err := structuredHeader(data, defaultOptions.Format)
+		return err == nil
+	}
+	magic := make([]byte, magicLength)
+	_, err := io.ReadFull(base64.NewDecoder(base64.StdEncoding,
+		bytes.NewReader(data)), magic)
+	return err == nil && bytes.Equal(magic, headerMagic)
+}
+
+//gitEncrypt encrypts the plaintext, with the keys recorded in the indexed
+//ciphertext if there is one (see recordedKmsProvider), writing it to w
+func gitEncrypt(w io.Writer, plaintext, indexed []byte) error {
+	kmsProvider, err := gitKmsProvider(indexed)
+	if err != nil {
+		return err
+	}
+	if defaultOptions.Format == "" {
+		return encryptAndValidate(w, bytes.NewReader(plaintext), false, false,
+			kmsProvider, defaultOptions.AAD)
+	}
+	encrypted, err := EncryptStructured(plaintext, defaultOptions.Format,
+		reencryptKeyRegex(indexed), kmsProvider, defaultOptions.AAD)
+	if err == nil {
+		err = validateStructured(encrypted, false, kmsProvider)
+	}
+	if err == nil {
+		_, err = w.Write(encrypted)
+	}
+	return err
+}
+
+//gitKmsProvider creates the KmsProvider for the keys recorded in the indexed
+//ciphertext, or from the input flags if there isn't one
+func gitKmsProvider(indexed []byte) (KmsProvider, error) {
+	if isEncrypted(indexed) {
+		return recordedKmsProvider(bytes.NewReader(indexed))
+	}
+	return getKmsProvider(defaultOptions.KMSProvider)
+}
+
+//gitSmudge decrypts the ciphertext read from r, see writeDecrypted
+func gitSmudge(w io.Writer, r io.Reader) error {
+	ciphertext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return writeDecrypted(w, ciphertext)
+}
+
+//writeDecrypted writes the plaintext of the ciphertext to w. If it can't be
+//decrypted, e.g. without access to the KMS key, or as it's a plaintext (git
+//diff gives textconv the working tree's file), it's written as it is instead,
+//so git still works.
+func writeDecrypted(w io.Writer, ciphertext []byte) error {
+	if !isEncrypted(ciphertext) {
+		_, err := w.Write(ciphertext)
+		return err
+	}
+	plaintext, err := decryptBytes(ciphertext)
+	defer zeroBytes(plaintext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mantle: leaving ciphertext as it is, as it "+
+			"couldn't be decrypted: %v\n", err)
+		plaintext = ciphertext
+	}
+	_, err = w.Write(plaintext)
+	return err
+}
+
+//decryptBytes decrypts the ciphertext, see decryptInput, detecting the KMS
+//provider unless it's given
+func decryptBytes(ciphertext []byte) ([]byte, error) {
+	kmsProvider, err := getDecryptKmsProvider()
+	if err != nil {
+		return nil, err
+	}
+	var plaintext bytes.Buffer
+	if err = decryptInput(&plaintext, bytes.NewReader(ciphertext),
+		kmsProvider); err != nil {
+		zeroBytes(plaintext.Bytes())
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+//gitFilterOptions returns the options given before git-init on the command
+//line, for the filters to use, checking only the patterns follow it. Only the
+//gitFilterFlags are returned.
+func gitFilterOptions(commandLine, patterns []string) ([]string, error) {
+	for i, arg := range commandLine {
+		if arg == "git-init" {
+			if len(commandLine)-i-1 != len(patterns) {
+				return nil, errors.New("options for the filters must be " +
+					"given before git-init")
+			}
+			return storedGitOptions(commandLine[:i]), nil
+		}
+	}
+	return nil, nil
+}
+
+//storedGitOptions returns the options that are gitFilterFlags, with their
+//values, noting any others that are left out
+func storedGitOptions(options []string) []string {
+	stored := []string{}
+	for i := 0; i < len(options); {
+		option, inlineValue := findOption(options[i])
+		end := i + optionLength(option, inlineValue)
+		if end > len(options) {
+			end = len(options)
+		}
+		if option != nil && gitFilterFlags[option.LongName] {
+			stored = append(stored, options[i:end]...)
+		} else {
+			noteUnstoredOption(options[i], option)
+		}
+		i = end
+	}
+	return stored
+}
+
+//findOption returns the option the arg is, e.g. --keyName, --keyName=name, -n
+//or -nname, and whether its value is part of the arg, or nil if it isn't one
+func findOption(arg string) (option *flags.Option, inlineValue bool) {
+	if strings.HasPrefix(arg, "--") {
+		name := strings.SplitN(arg[2:], "=", 2)
+		return Parser.FindOptionByLongName(name[0]), len(name) == 2
+	}
+	if len(arg) < 2 || arg[0] != '-' {
+		return nil, false
+	}
+	return Parser.FindOptionByShortName(rune(arg[1])), len(arg) > 2
+}
+
+//optionLength returns the number of args the option takes up, i.e. two unless
+//it's a bool flag or its value is part of the arg
+func optionLength(option *flags.Option, inlineValue bool) int {
+	if option == nil || inlineValue || option.Field().Type.Kind() == reflect.Bool {
+		return 1
+	}
+	return 2
+}
+
+//noteUnstoredOption notes the option isn't stored in the git config, and the
+//environment variable to set for the filters instead, if it has one
+func noteUnstoredOption(arg string, option *flags.Option) {
+	if option == nil || option.EnvDefaultKey == "" {
+		fmt.Fprintf(statusOutput, "%s isn't stored in the git config\n", arg)
+		return
+	}
+	fmt.Fprintf(statusOutput, "%s isn't stored in the git config, set %s for "+
+		"the filters instead\n", arg, option.EnvDefaultKey)
+}
+
+//configureGit configures the repository's mantle filter and diff driver, to
+//run the mantle command (i.e. mantle and its options)
+func configureGit(command []string) error {
+	mantle := shellQuote(command)
+	for _, config := range [][2]string{
+		{"filter.mantle.clean", mantle + " git-filter clean %f"},
+		{"filter.mantle.smudge", mantle + " git-filter smudge %f"},
+		{"filter.mantle.required", "true"},
+		{"diff.mantle.textconv", mantle + " git-textconv"},
+	} {
+		output, err := exec.Command("git", "config", config[0],
+			config[1]).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git config %s failed: %w: %s", config[0], err,
+				bytes.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+//shellQuote joins the args, single quoting any that the shell would
+//otherwise interpret, as git runs the filters with the shell
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if !shellSafe.MatchString(arg) {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+//addGitAttributes adds the gitAttributes for each pattern to the
+//.gitattributes file at the root of the repository
+func addGitAttributes(patterns []string) error {
+	root, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return fmt.Errorf("git rev-parse failed, is this a git repository? %w",
+			err)
+	}
+	name := filepath.Join(strings.TrimSpace(string(root)), ".gitattributes")
+	attributes, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeOutput(name, appendGitAttributes(attributes, patterns))
+}
+
+//appendGitAttributes appends a line giving the gitAttributes to each
+//pattern, unless it's there already
+func appendGitAttributes(attributes []byte, patterns []string) []byte {
+	lines := strings.Split(string(attributes), "\n")
+	if len(attributes) > 0 && !bytes.HasSuffix(attributes, []byte("\n")) {
+		attributes = append(attributes, '\n')
+	}
+	for _, pattern := range patterns {
+		line := pattern + " " + gitAttributes
+		if !containsString(lines, line) {
+			attributes = append(attributes, line+"\n"...)
+		}
+	}
+	return attributes
+}
+
+//containsString reports whether the slice contains the string
+func containsString(slice []string, s string) bool {
+	for _, element := range slice {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+var gitFilterOptionsTests = []struct {
+	commandLine []string
+	patterns    []string
+	options     []string
+}{
+	{[]string{"git-init", "cipher.txt"}, []string{"cipher.txt"}, []string{}},
+	{[]string{"-m", "LOCAL", "git-init", "a", "b"}, []string{"a", "b"},
+		[]string{"-m", "LOCAL"}},
+	{[]string{"git-init", "-m", "LOCAL", "a"}, []string{"a"}, nil},
+	{[]string{"-mPKCS11", "--pkcs11Pin", "1234", "--keyName=label",
+		"--pkcs11Pin=1234", "--vaultRoleId", "role", "git-init", "a"},
+		[]string{"a"}, []string{"-mPKCS11", "--keyName=label"}},
+}
+
+func TestGitFilterOptions(t *testing.T) {
+	for _, test := range gitFilterOptionsTests {
+		options, err := gitFilterOptions(test.commandLine, test.patterns)
+		if !reflect.DeepEqual(options, test.options) ||
+			(err != nil) != (test.options == nil) {
+			t.Errorf("Got %q (%v) for %q", options, err, test.commandLine)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	quoted := shellQuote([]string{"/usr/bin/mantle", "--aad", "env=prod",
+		"-n", "it's a key"})
+	want := `/usr/bin/mantle --aad env=prod -n 'it'\''s a key'`
+	if quoted != want {
+		t.Errorf("Got %s, want %s", quoted, want)
+	}
+}
+
+func TestAppendGitAttributes(t *testing.T) {
+	attributes := appendGitAttributes([]byte("*.png binary\ncipher.txt "+
+		gitAttributes), []string{"cipher.txt", "secrets/*.env"})
+	want := "*.png binary\ncipher.txt " + gitAttributes + "\nsecrets/*.env " +
+		gitAttributes + "\n"
+	if string(attributes) != want {
+		t.Errorf("Got %q, want %q", attributes, want)
+	}
+}
+
+//gitTest changes to a new git repository for the test, skipping it if git
+//isn't installed
+func gitTest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	useStubProvider(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if output, err := exec.Command("git", "init").CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v: %s", err, output)
+	}
+}
+
+//gitCleanTest cleans the plaintext of cipher.txt
+func gitCleanTest(t *testing.T, plaintext []byte) []byte {
+	var ciphertext bytes.Buffer
+	if err := gitClean(&ciphertext, bytes.NewReader(plaintext),
+		"cipher.txt"); err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext.Bytes()
+}
+
+//gitSmudgeTest smudges the ciphertext
+func gitSmudgeTest(t *testing.T, ciphertext []byte) []byte {
+	var plaintext bytes.Buffer
+	if err := gitSmudge(&plaintext, bytes.NewReader(ciphertext)); err != nil {
+		t.Fatal(err)
+	}
+	return plaintext.Bytes()
+}
+
+func TestGitCleanAndSmudge(t *testing.T) {
+	gitTest(t)
+	plaintext := []byte("helloworld\n")
+	ciphertext := gitCleanTest(t, plaintext)
+	smudged := gitSmudgeTest(t, ciphertext)
+	if !isEncrypted(ciphertext) || !bytes.Equal(smudged, plaintext) {
+		t.Errorf("Got %q smudging %q", smudged, ciphertext)
+	}
+}
+
+func TestGitCleanIndexed(t *testing.T) {
+	gitTest(t)
+	plaintext := []byte("helloworld\n")
+	ciphertext := gitCleanTest(t, plaintext)
+	ioutil.WriteFile("cipher.txt", ciphertext, 0600)
+	if output, err := exec.Command("git", "add", "cipher.txt").CombinedOutput(); err != nil {
+		t.Fatalf("git add failed: %v: %s", err, output)
+	}
+	if cleaned := gitCleanTest(t, plaintext); !bytes.Equal(cleaned, ciphertext) {
+		t.Error("Expected the unchanged plaintext to be cleaned to the indexed ciphertext")
+	}
+	if cleaned := gitCleanTest(t, ciphertext); !bytes.Equal(cleaned, ciphertext) {
+		t.Error("Expected the ciphertext to be cleaned as it is")
+	}
+	if cleaned := gitCleanTest(t, []byte("changed\n")); bytes.Equal(cleaned,
+		ciphertext) || !isEncrypted(cleaned) {
+		t.Error("Expected the changed plaintext to be encrypted")
+	}
+}
+
+func TestGitSmudgeUndecryptable(t *testing.T) {
+	for _, ciphertext := range []string{"plaintext\n", "TU5UTAIFU1RVQgAg\n"} {
+		if smudged := gitSmudgeTest(t, []byte(ciphertext)); string(smudged) != ciphertext {
+			t.Errorf("Got %q, want %q as it is", smudged, ciphertext)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	formatVersion1 = 1
+	//formatVersion2 adds optional header fields after the nonce
+	formatVersion2       = 2
+	currentFormatVersion = formatVersion2
+	magicLength          = 4
+)
+
+//header field tags, fields are encoded as tag[1]length[2]value[length]
+const (
+	fieldChunkSize         = 1
+	fieldEncryptionContext = 2
+	fieldKeyID             = 3
+	fieldCreated           = 4
+	fieldAADKeys           = 5
+)
+
+//headerMagic prefixes every ciphertext written in a versioned format. Legacy
+//(headerless) ciphertexts start with AES-GCM output instead.
+var headerMagic = []byte("MNTL")
+
+//header describes a versioned ciphertext, everything that's needed to
+//decrypt the data that follows it
+type header struct {
+	version      byte
+	providerID   string
+	encryptedDek []byte
+	nonce        []byte
+	//chunkSize is the plaintext size of each chunk of a streamed ciphertext,
+	//or zero if the data was sealed in one go
+	chunkSize uint32
+	//encryptionContext is replayed to the KMS provider when decrypting the
+	//DEK, see encryptionContextKmsProvider
+	encryptionContext map[string]string
+	//keyID identifies the key that encrypted the DEK, see keyIDKmsProvider
+	keyID string
+	//created is when the ciphertext was encrypted, in seconds since the Unix
+	//epoch, or zero if it wasn't recorded
+	created int64
+	//aadKeys are the keys of the AAD the ciphertext was encrypted with, the
+	//values aren't recorded
+	aadKeys []string
+}
+
+//headerField encodes and decodes an optional header field
+type headerField struct {
+	tag byte
+	//encode returns the field's value, or nil if it isn't set
+	encode func(h header) []byte
+	decode func(h *header, value []byte) error
+}
+
+var headerFields = []headerField{
+	{fieldChunkSize, encodeChunkSize, decodeChunkSize},
+	{fieldEncryptionContext, encodeEncryptionContext, decodeEncryptionContext},
+	{fieldKeyID, encodeKeyID, decodeKeyID},
+	{fieldCreated, encodeCreated, decodeCreated},
+	{fieldAADKeys, encodeAADKeys, decodeAADKeys},
+}
+
+//hasHeader reports whether the ciphertext starts with the versioned header
+//magic
+func hasHeader(cipherBytes []byte) bool {
+	return bytes.HasPrefix(cipherBytes, headerMagic)
+}
+
+//marshal returns the binary encoding of the header:
+//magic[4]version[1]providerIDLength[1]providerID[n]dekLength[2]nonceLength[1]
+//encryptedDEK[dekLength]nonce[nonceLength]
+//followed, from version 2, by fieldsLength[2]fields[fieldsLength]
+//It fails rather than truncating a length that doesn't fit.
+func (h header) marshal() ([]byte, error) {
+	fields, err := h.marshalFields()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkLengths([]headerLength{
+		{"provider ID", len(h.providerID), math.MaxUint8},
+		{"encrypted DEK", len(h.encryptedDek), math.MaxUint16},
+		{"nonce", len(h.nonce), math.MaxUint8},
+		{"header fields", len(fields), math.MaxUint16},
+	}); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(headerMagic)
+	buf.WriteByte(h.version)
+	buf.WriteByte(byte(len(h.providerID)))
+	buf.WriteString(h.providerID)
+	binary.Write(&buf, binary.BigEndian, uint16(len(h.encryptedDek)))
+	buf.WriteByte(byte(len(h.nonce)))
+	buf.Write(h.encryptedDek)
+	buf.Write(h.nonce)
+	if h.version >= formatVersion2 {
+		binary.Write(&buf, binary.BigEndian, uint16(len(fields)))
+		buf.Write(fields)
+	}
+	return buf.Bytes(), nil
+}
+
+//marshalFields returns the binary encoding of every optional field that's set
+func (h header) marshalFields() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, field := range headerFields {
+		if value := field.encode(h); value != nil {
+			if err := checkLengths([]headerLength{{fmt.Sprintf(
+				"header field %d", field.tag), len(value),
+				math.MaxUint16}}); err != nil {
+				return nil, err
+			}
+			buf.WriteByte(field.tag)
+			binary.Write(&buf, binary.BigEndian, uint16(len(value)))
+			buf.Write(value)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+//headerLength is the length of a header value, and the maximum its encoding
+//allows
+type headerLength struct {
+	name        string
+	length, max int
+}
+
+//checkLengths fails if any of the lengths is longer than its maximum
+func checkLengths(lengths []headerLength) error {
+	for _, l := range lengths {
+		if l.length > l.max {
+			return fmt.Errorf("the %s is %d bytes, longer than the %d bytes a "+
+				"header can record", l.name, l.length, l.max)
+		}
+	}
+	return nil
+}
+
+//parseHeader decodes the header at the start of the ciphertext, returning it
+//along with the raw header bytes and the encrypted data that follows
+func parseHeader(cipherBytes []byte) (h header, raw, data []byte, err error) {
+	if h, raw, err = readHeader(bytes.NewReader(cipherBytes)); err != nil {
+		return
+	}
+	return h, raw, cipherBytes[len(raw):], nil
+}
+
+//readHeader reads the header from the start of a ciphertext stream, returning
+//it along with the raw header bytes, or the bytes read if it's invalid
+func readHeader(r io.Reader) (h header, raw []byte, err error) {
+	var buf bytes.Buffer
+	hr := &headerReader{r: io.TeeReader(r, &buf)}
+	if !bytes.Equal(hr.read(magicLength), headerMagic) {
+		err = fmt.Errorf("%w: CipherText doesn't start with a valid header",
+			ErrInvalidCiphertext)
+		return h, buf.Bytes(), err
+	}
+	h.version = hr.readByte()
+	if err = checkVersion(hr, h.version); err != nil {
+		return h, buf.Bytes(), err
+	}
+	h.providerID = string(hr.read(int(hr.readByte())))
+	dekLength := int(hr.readUint16())
+	nonceLength := int(hr.readByte())
+	h.encryptedDek = hr.read(dekLength)
+	h.nonce = hr.read(nonceLength)
+	if h.version >= formatVersion2 {
+		err = h.readFields(hr.read(int(hr.readUint16())))
+	}
+	if hr.err != nil {
+		err = fmt.Errorf("%w: CipherText header is truncated", ErrInvalidCiphertext)
+	}
+	return h, buf.Bytes(), err
+}
+
+//checkVersion checks the format version is one this release can decrypt
+func checkVersion(hr *headerReader, version byte) error {
+	if hr.err == nil && (version < formatVersion1 || version > currentFormatVersion) {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	return nil
+}
+
+//readFields decodes the optional header fields
+func (h *header) readFields(fields []byte) error {
+	fr := &headerReader{r: bytes.NewReader(fields)}
+	for remaining := len(fields); remaining > 0; remaining = len(fields) - fr.n {
+		tag := fr.readByte()
+		value := fr.read(int(fr.readUint16()))
+		if fr.err != nil {
+			return fmt.Errorf("%w: CipherText header field is truncated",
+				ErrInvalidCiphertext)
+		}
+		if err := h.decodeField(tag, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//decodeField decodes a single optional header field
+func (h *header) decodeField(tag byte, value []byte) error {
+	for _, field := range headerFields {
+		if field.tag == tag {
+			return field.decode(h, value)
+		}
+	}
+	return fmt.Errorf("%w: unknown header field %d", ErrUnsupportedVersion, tag)
+}
+
+func encodeChunkSize(h header) []byte {
+	if h.chunkSize == 0 {
+		return nil
+	}
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, h.chunkSize)
+	return value
+}
+
+func decodeChunkSize(h *header, value []byte) error {
+	if len(value) != 4 {
+		return fmt.Errorf("%w: invalid chunk size", ErrInvalidCiphertext)
+	}
+	h.chunkSize = binary.BigEndian.Uint32(value)
+	return nil
+}
+
+func encodeEncryptionContext(h header) []byte {
+	return encodeKeyValues(h.encryptionContext)
+}
+
+func decodeEncryptionContext(h *header, value []byte) (err error) {
+	h.encryptionContext, err = decodeKeyValues(value)
+	return
+}
+
+func encodeKeyID(h header) []byte {
+	if h.keyID == "" {
+		return nil
+	}
+	return []byte(h.keyID)
+}
+
+func decodeKeyID(h *header, value []byte) error {
+	h.keyID = string(value)
+	return nil
+}
+
+func encodeCreated(h header) []byte {
+	if h.created == 0 {
+		return nil
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(h.created))
+	return value
+}
+
+func decodeCreated(h *header, value []byte) error {
+	if len(value) != 8 {
+		return fmt.Errorf("%w: invalid created time", ErrInvalidCiphertext)
+	}
+	h.created = int64(binary.BigEndian.Uint64(value))
+	return nil
+}
+
+func encodeAADKeys(h header) (value []byte) {
+	for _, key := range h.aadKeys {
+		value = appendLengthPrefixed(value, key)
+	}
+	return
+}
+
+func decodeAADKeys(h *header, value []byte) error {
+	r := bytes.NewReader(value)
+	for r.Len() > 0 {
+		key, err := readLengthPrefixed(r)
+		if err != nil {
+			return fmt.Errorf("%w: truncated AAD keys", ErrInvalidCiphertext)
+		}
+		h.aadKeys = append(h.aadKeys, key)
+	}
+	return nil
+}
+
+//headerReader reads values from a header, remembering the first error so it
+//can be checked once all values have been read
+type headerReader struct {
+	r   io.Reader
+	n   int
+	err error
+}
+
+//read returns the next n bytes
+func (hr *headerReader) read(n int) []byte {
+	b := make([]byte, n)
+	if hr.err == nil {
+		var read int
+		read, hr.err = io.ReadFull(hr.r, b)
+		hr.n += read
+	}
+	return b
+}
+
+func (hr *headerReader) readByte() byte {
+	return hr.read(1)[0]
+}
+
+func (hr *headerReader) readUint16() uint16 {
+	return binary.BigEndian.Uint16(hr.read(2))
+}
@@ -0,0 +1,248 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//stubKms "encrypts" by returning the DEK unchanged
+type stubKms struct{}
+
+func (s stubKms) Name() string {
+	return "STUB"
+}
+
+func (s stubKms) Encrypt(ctx context.Context, dek []byte) ([]byte, error) {
+	return dek, nil
+}
+
+func (s stubKms) Decrypt(ctx context.Context, encryptedDek []byte) ([]byte, error) {
+	return encryptedDek, nil
+}
+
+func (s stubKms) encryptedDekLength() int {
+	return dekLength
+}
+
+var headerTests = []header{
+	{
+		version:      formatVersion1,
+		providerID:   "AWS",
+		encryptedDek: bytes.Repeat([]byte{1}, 185),
+		nonce:        bytes.Repeat([]byte{2}, nonceLength),
+	},
+	{
+		version:      formatVersion2,
+		providerID:   "GCP",
+		encryptedDek: bytes.Repeat([]byte{1}, 114),
+		nonce:        bytes.Repeat([]byte{2}, streamNoncePrefixLength),
+		chunkSize:    defaultChunkSize,
+	},
+	{
+		version:           formatVersion2,
+		providerID:        "AWS",
+		encryptedDek:      bytes.Repeat([]byte{1}, 185),
+		nonce:             bytes.Repeat([]byte{2}, nonceLength),
+		encryptionContext: map[string]string{"service": "billing", "env": ""},
+	},
+	{
+		version:      formatVersion2,
+		providerID:   "GCP",
+		encryptedDek: bytes.Repeat([]byte{1}, 114),
+		nonce:        bytes.Repeat([]byte{2}, nonceLength),
+		keyID:        "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+	},
+	{
+		version:      formatVersion2,
+		providerID:   "LOCAL",
+		encryptedDek: bytes.Repeat([]byte{1}, 60),
+		nonce:        bytes.Repeat([]byte{2}, nonceLength),
+		created:      1792281600,
+		aadKeys:      []string{"app", "env"},
+	},
+}
+
+//marshalHeader returns the binary encoding of the header, failing the test if
+//it can't be encoded
+func marshalHeader(t *testing.T, h header) []byte {
+	raw, err := h.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	for _, h := range headerTests {
+		raw := marshalHeader(t, h)
+		parsed, rawHeader, data, err := parseHeader(append(raw, []byte("data")...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parsed, h) {
+			t.Errorf("Got %+v, want %+v", parsed, h)
+		}
+		if !bytes.Equal(rawHeader, raw) || string(data) != "data" {
+			t.Errorf("Header and data weren't split correctly")
+		}
+	}
+}
+
+var invalidHeaderTests = []struct {
+	name        string
+	cipherBytes []byte
+}{
+	{"legacy", make([]byte, 200)},
+	{"truncated", []byte("MNTL\x01\x03AWS\x00")},
+	{"short keys", []byte("MNTL\x01\x03AWS\x00\xb9\x0c")},
+	{"unsupported version", []byte("MNTL\x09\x03AWS\x00\x00\x00")},
+	{"truncated fields", []byte("MNTL\x02\x03AWS\x00\x00\x00\x00\x05\x01\x00\x04")},
+	{"unknown field", []byte("MNTL\x02\x03AWS\x00\x00\x00\x00\x03\xff\x00\x00")},
+	{"invalid created time", []byte("MNTL\x02\x03AWS\x00\x00\x00\x00\x05\x04\x00\x02\x00\x01")},
+	{"truncated encryption context", []byte("MNTL\x02\x03AWS\x00\x00\x00\x00\x08\x02\x00\x05\x00\x00\x00\x09a")},
+}
+
+func TestParseInvalidHeader(t *testing.T) {
+	for _, test := range invalidHeaderTests {
+		if _, _, _, err := parseHeader(test.cipherBytes); err == nil {
+			t.Errorf("Expected error parsing %s header", test.name)
+		}
+	}
+}
+
+var oversizedHeaderTests = []struct {
+	name string
+	h    header
+}{
+	{"provider ID", header{version: formatVersion2,
+		providerID: strings.Repeat("A", 256)}},
+	{"encrypted DEK", header{version: formatVersion2,
+		encryptedDek: make([]byte, 65536)}},
+	{"encryption context", header{version: formatVersion2,
+		encryptionContext: map[string]string{"k": strings.Repeat("v", 65536)}}},
+	{"header fields", header{version: formatVersion2,
+		keyID: strings.Repeat("k", 40000), aadKeys: []string{
+			strings.Repeat("a", 40000)}}},
+}
+
+func TestMarshalOversizedHeader(t *testing.T) {
+	for _, test := range oversizedHeaderTests {
+		if raw, err := test.h.marshal(); err == nil {
+			t.Errorf("Expected error marshalling a header with an oversized %s, "+
+				"got %d bytes", test.name, len(raw))
+		}
+	}
+}
+
+func TestCipherBytesWithHeader(t *testing.T) {
+	plaintext := []byte("helloworld")
+	cipherBytes, err := CipherBytesFromPrimitives(plaintext, false, true, stubKms{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(cipherBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasHeader(decoded) {
+		t.Fatal("Expected ciphertext to start with a header")
+	}
+	result, err := PlainTextFromPrimitives(decoded, stubKms{}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Got %s (%v), want %s", result, err, plaintext)
+	}
+}
+
+func TestPlainTextLegacyFormat(t *testing.T) {
+	plaintext := []byte("helloworld")
+	dek, _ := randByteSlice(dekLength)
+	nonce, _ := randByteSlice(nonceLength)
+	sealed, err := cipherText(plaintext, dek, nonce, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := append(append(sealed, nonce...), dek...)
+	result, err := PlainTextFromPrimitives(legacy, stubKms{}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Got %s (%v), want %s", result, err, plaintext)
+	}
+}
+
+func TestTamperedHeader(t *testing.T) {
+	sealed, err := sealWithNewDek([]byte("helloworld"), stubKms{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a bit in the nonce, which is authenticated as part of the header
+	h, rawHeader, _, _ := parseHeader(sealed)
+	sealed[bytes.Index(rawHeader, h.nonce)] ^= 1
+	_, err = PlainTextFromPrimitives(sealed, stubKms{}, nil)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Got %v, want %v", err, ErrAuthenticationFailed)
+	}
+}
+
+//contextKms is a stubKms that binds an encryption context to the DEK, by
+//appending it to the "encrypted" DEK
+type contextKms struct {
+	stubKms
+	context map[string]string
+}
+
+func (c contextKms) Encrypt(ctx context.Context, dek []byte) ([]byte, error) {
+	return append(dek, encodeKeyValues(c.context)...), nil
+}
+
+func (c contextKms) encryptionContext() map[string]string {
+	return c.context
+}
+
+func (c contextKms) decryptWithContext(ctx context.Context, encryptedDek []byte,
+	encryptionContext map[string]string) ([]byte, error) {
+	if !bytes.Equal(encryptedDek[dekLength:], encodeKeyValues(encryptionContext)) {
+		return nil, kmsError(c.Name(), false, errors.New("wrong encryption context"))
+	}
+	return encryptedDek[:dekLength], nil
+}
+
+func TestEncryptionContextReplayed(t *testing.T) {
+	plaintext := []byte("helloworld")
+	encryptionContext := map[string]string{"service": "billing"}
+	sealed, err := sealWithNewDek(plaintext, contextKms{context: encryptionContext}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _, _, _ := parseHeader(sealed); !reflect.DeepEqual(h.encryptionContext,
+		encryptionContext) {
+		t.Errorf("Got encryption context %v, want %v", h.encryptionContext,
+			encryptionContext)
+	}
+	// the decrypting provider's own context is ignored in favour of the header's
+	result, err := PlainTextFromPrimitives(sealed, contextKms{}, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Got %s (%v), want %s", result, err, plaintext)
+	}
+	if _, err = PlainTextFromPrimitives(sealed, stubKms{}, nil); !errors.Is(err,
+		ErrUnsupportedProvider) {
+		t.Errorf("Got %v, want %v", err, ErrUnsupportedProvider)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	Parser.AddCommand("inspect",
+		"Shows what protects encrypted text, without decrypting it",
+		"Parses the ciphertext's header, and outputs its format version, the KMS "+
+			"providers and keys the DEK was encrypted with, the nonce, sizes, "+
+			"timestamps and the keys of the AAD. No KMS access is needed.",
+		&inspectCommand)
+}
+
+//InspectCommand type
+type InspectCommand struct {
+	Filepath string `short:"f" long:"filepath" description:"Path of file to get encrypted string from, or - for stdin" default:"./cipher.txt"`
+	JSON     bool   `long:"json" description:"Writes the metadata as JSON"`
+}
+
+var inspectCommand InspectCommand
+
+//Envelope is the metadata of a ciphertext, i.e. everything that can be read
+//from it without decrypting it. Legacy (headerless) ciphertexts have version
+//0, and only record the encrypted DEK and nonce, so their provider and sizes
+//are a best guess.
+type Envelope struct {
+	Version          int           `json:"version"`
+	Provider         string        `json:"provider"`
+	Threshold        int           `json:"threshold,omitempty"`
+	Keys             []EnvelopeKey `json:"keys"`
+	Nonce            string        `json:"nonce"`
+	EncryptedDekSize int           `json:"encryptedDekSize"`
+	HeaderSize       int           `json:"headerSize"`
+	CiphertextSize   int64         `json:"ciphertextSize"`
+	PlaintextSize    int64         `json:"plaintextSize"`
+	ChunkSize        uint32        `json:"chunkSize,omitempty"`
+	Created          *time.Time    `json:"created,omitempty"`
+	Modified         *time.Time    `json:"modified,omitempty"`
+	AADKeys          []string      `json:"aadKeys,omitempty"`
+}
+
+//EnvelopeKey is a KMS key the DEK (or a share of it) was encrypted with
+type EnvelopeKey struct {
+	Provider          string            `json:"provider"`
+	KeyID             string            `json:"keyId,omitempty"`
+	EncryptionContext map[string]string `json:"encryptionContext,omitempty"`
+	EncryptedDekSize  int               `json:"encryptedDekSize"`
+}
+
+//Execute executes the InspectCommand
+func (x *InspectCommand) Execute(args []string) error {
+	envelope, err := inspectFile(x.Filepath)
+	if err != nil {
+		return err
+	}
+	if x.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(envelope)
+	}
+	return envelope.write(os.Stdout)
+}
+
+//inspectFile inspects the base64 encoded ciphertext file ("-" for stdin),
+//recording when the file was last modified
+func inspectFile(filepath string) (envelope Envelope, err error) {
+	input, err := openInput(filepath)
+	if err != nil {
+		return
+	}
+	defer input.Close()
+	if envelope, err = Inspect(base64.NewDecoder(base64.StdEncoding,
+		input)); err != nil {
+		return
+	}
+	if file, ok := input.(*os.File); ok {
+		if info, statErr := file.Stat(); statErr == nil {
+			modified := info.ModTime().UTC()
+			envelope.Modified = &modified
+		}
+	}
+	return
+}
+
+//Inspect reads the metadata of the (decoded) ciphertext read from r. The
+//encrypted data is only counted, so a streamed ciphertext isn't held in
+//memory.
+func Inspect(r io.Reader) (envelope Envelope, err error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(magicLength); bytes.Equal(magic, headerMagic) {
+		envelope, err = inspectHeader(br)
+	} else {
+		envelope, err = inspectLegacy(br)
+	}
+	var corruptInput base64.CorruptInputError
+	if errors.As(err, &corruptInput) {
+		err = fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return
+}
+
+//inspectHeader reads the metadata recorded in a versioned ciphertext's header
+func inspectHeader(r io.Reader) (envelope Envelope, err error) {
+	h, rawHeader, err := readHeader(r)
+	if err != nil {
+		return
+	}
+	dataLength, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return
+	}
+	threshold, wrapped, err := wrappedDeks(h)
+	envelope = Envelope{
+		Version:          int(h.version),
+		Provider:         h.providerID,
+		Threshold:        threshold,
+		Keys:             envelopeKeys(wrapped),
+		Nonce:            hex.EncodeToString(h.nonce),
+		EncryptedDekSize: len(h.encryptedDek),
+		HeaderSize:       len(rawHeader),
+		CiphertextSize:   int64(len(rawHeader)) + dataLength,
+		PlaintextSize:    plaintextSize(h.chunkSize, dataLength),
+		ChunkSize:        h.chunkSize,
+		AADKeys:          h.aadKeys,
+	}
+	if h.created != 0 {
+		created := time.Unix(h.created, 0).UTC()
+		envelope.Created = &created
+	}
+	return
+}
+
+//inspectLegacy guesses the metadata of a legacy ciphertext, i.e.
+//sealedData nonce[12]encryptedDEK, from the provider detected for it, taking
+//the key ID from an AWS ciphertext blob
+func inspectLegacy(r io.Reader) (envelope Envelope, err error) {
+	cipherBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	providerID, encDekLength := "AWS", awsCiphertextBlobLength(cipherBytes)
+	if encDekLength == 0 {
+		providerID, encDekLength = "GCP", GcpKms{}.encryptedDekLength()
+	}
+	if err = checkCipherTextLength(cipherBytes, encDekLength); err != nil {
+		return
+	}
+	dataLength := len(cipherBytes) - encDekLength - nonceLength
+	key := EnvelopeKey{Provider: providerID, EncryptedDekSize: encDekLength}
+	if providerID == "AWS" {
+		key.KeyID = awsCiphertextBlobKeyID(cipherBytes[dataLength+nonceLength:])
+	}
+	return Envelope{
+		Provider:         providerID,
+		Keys:             []EnvelopeKey{key},
+		Nonce:            hex.EncodeToString(cipherBytes[dataLength : dataLength+nonceLength]),
+		EncryptedDekSize: encDekLength,
+		CiphertextSize:   int64(len(cipherBytes)),
+		PlaintextSize:    plaintextSize(0, int64(dataLength)),
+	}, nil
+}
+
+//envelopeKeys returns the keys the wrapped DEKs were encrypted with
+func envelopeKeys(wrapped []wrappedDek) []EnvelopeKey {
+	keys := make([]EnvelopeKey, len(wrapped))
+	for i, w := range wrapped {
+		keys[i] = EnvelopeKey{
+			Provider:          w.providerID,
+			KeyID:             wrappedKeyID(w),
+			EncryptionContext: w.encryptionContext,
+			EncryptedDekSize:  len(w.encryptedDek),
+		}
+	}
+	return keys
+}
+
+//wrappedKeyID returns the ID of the key that wrapped the DEK, preferring the
+//key ARN in an AWS ciphertext blob over the recorded key name, which may be an
+//alias or bare key ID
+func wrappedKeyID(w wrappedDek) string {
+	if strings.EqualFold(w.providerID, "AWS") {
+		if keyArn := awsCiphertextBlobKeyID(w.encryptedDek); keyArn != "" {
+			return keyArn
+		}
+	}
+	return w.keyID
+}
+
+//plaintextSize returns the size of the plaintext sealed in the encrypted
+//data, i.e. less a GCM tag for each chunk
+func plaintextSize(chunkSize uint32, dataLength int64) int64 {
+	chunks := int64(1)
+	if chunkSize > 0 {
+		sealedChunkSize := int64(chunkSize) + gcmTagLength
+		chunks = (dataLength + sealedChunkSize - 1) / sealedChunkSize
+	}
+	if size := dataLength - chunks*gcmTagLength; size > 0 {
+		return size
+	}
+	return 0
+}
+
+//write writes the envelope as a table of names and values, leaving out
+//anything that wasn't recorded
+func (e Envelope) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, field := range e.fields() {
+		if field[1] != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+		}
+	}
+	return tw.Flush()
+}
+
+//fields returns the name and value of every field, formatted for display
+func (e Envelope) fields() [][2]string {
+	fields := [][2]string{
+		{"Format version", formatVersionName(e.Version)},
+		{"Provider", e.Provider},
+		{"Threshold", thresholdName(e.Threshold, len(e.Keys))},
+	}
+	for _, key := range e.Keys {
+		fields = append(fields, [2]string{"Key", key.String()})
+	}
+	return append(fields, [][2]string{
+		{"Nonce", e.Nonce},
+		{"Encrypted DEK size", fmt.Sprintf("%d bytes", e.EncryptedDekSize)},
+		{"Ciphertext size", fmt.Sprintf("%d bytes", e.CiphertextSize)},
+		{"Plaintext size", fmt.Sprintf("%d bytes", e.PlaintextSize)},
+		{"Chunk size", chunkSizeName(e.ChunkSize)},
+		{"Created", timeName(e.Created)},
+		{"Modified", timeName(e.Modified)},
+		{"AAD keys", strings.Join(e.AADKeys, ", ")},
+	}...)
+}
+
+//String returns the key's provider and ID, and any encryption context
+func (k EnvelopeKey) String() string {
+	keyID := k.KeyID
+	if keyID == "" {
+		keyID = "(key ID not recorded)"
+	}
+	if len(k.EncryptionContext) == 0 {
+		return k.Provider + " " + keyID
+	}
+	pairs := make([]string, 0, len(k.EncryptionContext))
+	for key, value := range k.EncryptionContext {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s %s, encryption context %s", k.Provider, keyID,
+		strings.Join(pairs, ","))
+}
+
+func formatVersionName(version int) string {
+	if version == 0 {
+		return "legacy (no header, provider and sizes are a best guess)"
+	}
+	return fmt.Sprint(version)
+}
+
+func thresholdName(threshold, keys int) string {
+	if threshold == 0 {
+		return ""
+	}
+	return fmt.Sprintf("any %d of the %d keys", threshold, keys)
+}
+
+func chunkSizeName(chunkSize uint32) string {
+	if chunkSize == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d bytes (streamed)", chunkSize)
+}
+
+func timeName(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,208 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//inspectSealed seals helloworld with the KmsProvider, and inspects it
+func inspectSealed(t *testing.T, kmsProvider KmsProvider,
+	aad AAD) ([]byte, Envelope) {
+	sealed, err := sealWithNewDek([]byte("helloworld"), kmsProvider, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := Inspect(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed, envelope
+}
+
+func TestInspect(t *testing.T) {
+	_, envelope := inspectSealed(t, stubKms{}, nil)
+	want := []EnvelopeKey{{Provider: "STUB", EncryptedDekSize: dekLength}}
+	if envelope.Version != currentFormatVersion || envelope.Provider != "STUB" ||
+		!reflect.DeepEqual(envelope.Keys, want) {
+		t.Errorf("Got %+v, want version %d with key %+v", envelope,
+			currentFormatVersion, want)
+	}
+}
+
+func TestInspectSizes(t *testing.T) {
+	sealed, envelope := inspectSealed(t, stubKms{}, nil)
+	_, rawHeader, _, _ := parseHeader(sealed)
+	if envelope.PlaintextSize != 10 || envelope.HeaderSize != len(rawHeader) ||
+		envelope.CiphertextSize != int64(len(sealed)) {
+		t.Errorf("Got sizes %+v", envelope)
+	}
+}
+
+func TestInspectCreatedAndAADKeys(t *testing.T) {
+	sealed, envelope := inspectSealed(t, stubKms{},
+		AAD{"env": "prod", "app": "billing"})
+	h, _, _, _ := parseHeader(sealed)
+	if envelope.Created == nil || envelope.Created.Unix() != h.created ||
+		!reflect.DeepEqual(envelope.AADKeys, []string{"app", "env"}) {
+		t.Errorf("Got created %v and AAD keys %v", envelope.Created,
+			envelope.AADKeys)
+	}
+}
+
+func TestInspectStreamed(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("a"), defaultChunkSize*5/2)
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, stubKms{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	w.Close()
+	envelope, err := Inspect(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.ChunkSize != defaultChunkSize ||
+		envelope.PlaintextSize != int64(len(plaintext)) {
+		t.Errorf("Got chunk size %d and plaintext size %d, want %d and %d",
+			envelope.ChunkSize, envelope.PlaintextSize, defaultChunkSize,
+			len(plaintext))
+	}
+}
+
+func TestInspectMulti(t *testing.T) {
+	_, envelope := inspectSealed(t, MultiKms{Providers: []KmsProvider{
+		newTestLocalKms(t), contextKms{context: map[string]string{"service": "billing"}},
+	}}, nil)
+	if envelope.Provider != multiProviderName || len(envelope.Keys) != 2 ||
+		envelope.Keys[0].Provider != "LOCAL" ||
+		envelope.Keys[1].EncryptionContext["service"] != "billing" {
+		t.Errorf("Got %+v", envelope)
+	}
+}
+
+func TestEnvelopeWrite(t *testing.T) {
+	_, envelope := inspectSealed(t, contextKms{
+		context: map[string]string{"service": "billing"}}, AAD{"env": "prod"})
+	var out strings.Builder
+	if err := envelope.write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Key:  ",
+		" STUB (key ID not recorded), encryption context service=billing\n",
+		"AAD keys:  ",
+		" env\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Got output:\n%s\nwant it to contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestInspectLegacy(t *testing.T) {
+	legacy := append(make([]byte, 26), bytes.Repeat([]byte{2}, nonceLength)...)
+	legacy = append(legacy, make([]byte, GcpKms{}.encryptedDekLength())...)
+	envelope, err := Inspect(bytes.NewReader(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Version != 0 || envelope.Provider != "GCP" ||
+		envelope.PlaintextSize != 10 ||
+		envelope.Nonce != strings.Repeat("02", nonceLength) {
+		t.Errorf("Got %+v", envelope)
+	}
+}
+
+//testAwsKeyArn is the key ARN in the blobs of awsBlobKms
+const testAwsKeyArn = "arn:aws:kms:eu-west-1:111122223333:key/" +
+	"1234abcd-12ab-34cd-56ef-1234567890ab"
+
+//testAwsCiphertextBlob returns a ciphertext blob recording testAwsKeyArn
+func testAwsCiphertextBlob() []byte {
+	blob := append([]byte{1, 2, 2, 0, 0x78}, testAwsKeyArn...)
+	return append(blob, make([]byte, AwsKms{}.encryptedDekLength()-len(blob))...)
+}
+
+//awsBlobKms is a stubKms named AWS, that records its KeyName as the key ID,
+//and "encrypts" DEKs to a ciphertext blob recording testAwsKeyArn
+type awsBlobKms struct {
+	stubKms
+	KeyName string
+}
+
+func (a awsBlobKms) Name() string {
+	return "AWS"
+}
+
+func (a awsBlobKms) Encrypt(ctx context.Context, dek []byte) ([]byte, error) {
+	return testAwsCiphertextBlob(), nil
+}
+
+func (a awsBlobKms) keyID() string {
+	return a.KeyName
+}
+
+func (a awsBlobKms) checkKeyID() error {
+	return nil
+}
+
+func TestInspectAwsKeyArn(t *testing.T) {
+	awsKms := awsBlobKms{KeyName: "alias/mantle"}
+	for _, kmsProvider := range []KmsProvider{awsKms,
+		MultiKms{Providers: []KmsProvider{stubKms{}, awsKms}}} {
+		_, envelope := inspectSealed(t, kmsProvider, nil)
+		if key := envelope.Keys[len(envelope.Keys)-1]; key.KeyID != testAwsKeyArn {
+			t.Errorf("Got key ID %s for %s, want %s", key.KeyID,
+				kmsProvider.Name(), testAwsKeyArn)
+		}
+	}
+}
+
+func TestInspectLegacyAwsKeyID(t *testing.T) {
+	keyArn := testAwsKeyArn
+	blob := testAwsCiphertextBlob()
+	legacy := append(make([]byte, 26+nonceLength), blob...)
+	envelope, err := Inspect(bytes.NewReader(legacy))
+	if err != nil || envelope.Provider != "AWS" || envelope.Keys[0].KeyID != keyArn {
+		t.Errorf("Got %+v (%v), want AWS key %s", envelope, err, keyArn)
+	}
+	// the key ID is left out if the blob doesn't record a key ARN
+	copy(legacy[26+nonceLength+5:], "alias/mantle")
+	if envelope, err = Inspect(bytes.NewReader(legacy)); err != nil ||
+		envelope.Keys[0].KeyID != "" {
+		t.Errorf("Got %+v (%v), want no key ID", envelope, err)
+	}
+}
+
+func TestInspectLegacyTooShort(t *testing.T) {
+	_, err := Inspect(bytes.NewReader(make([]byte, 50)))
+	if !errors.Is(err, ErrCiphertextTooShort) {
+		t.Errorf("Got %v, want %v", err, ErrCiphertextTooShort)
+	}
+}
+
+func TestInspectTruncatedHeader(t *testing.T) {
+	_, err := Inspect(strings.NewReader("MNTL\x02\x03AWS\x00"))
+	if !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Got %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
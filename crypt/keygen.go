@@ -0,0 +1,73 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+func init() {
+	Parser.AddCommand("keygen",
+		"Creates a new key for the LOCAL KMS provider",
+		"Generates a random 256-bit key-encryption key, and writes it base64 "+
+			"encoded to a new file, readable only by you.",
+		&keygenCommand)
+}
+
+//KeygenCommand type
+type KeygenCommand struct {
+	TargetFilepath string `short:"t" long:"targetFilepath" description:"Path of new file to write the key to" default:"./mantle.key"`
+	WriteToStdout  bool   `short:"o" long:"stdout" description:"Writes the key to console"`
+}
+
+var keygenCommand KeygenCommand
+
+//Execute executes the KeygenCommand
+func (x *KeygenCommand) Execute(args []string) error {
+	key, err := NewLocalKey()
+	if err != nil {
+		return err
+	}
+	if x.WriteToStdout {
+		_, err = fmt.Println(key)
+		return err
+	}
+	if err = writeNewFile(x.TargetFilepath, []byte(key+"\n"), 0600); err == nil {
+		fmt.Printf("Key available at %s\n", x.TargetFilepath)
+	}
+	return err
+}
+
+//NewLocalKey returns a new random key for the LOCAL KMS provider, base64
+//encoded
+func NewLocalKey() (string, error) {
+	key, err := randByteSlice(localKeyLength)
+	return base64.StdEncoding.EncodeToString(key), err
+}
+
+//writeNewFile writes the data to a file that mustn't already exist
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//addKeyValue parses a key=value flag value, adding it to the map so the flag
+//can be repeated
+func addKeyValue(m *map[string]string, name, value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("expected key=value for %s, got %q", name, value)
+	}
+	if _, ok := (*m)[pair[0]]; ok {
+		return fmt.Errorf("%s key %q given more than once", name, pair[0])
+	}
+	if *m == nil {
+		*m = map[string]string{}
+	}
+	(*m)[pair[0]] = pair[1]
+	return nil
+}
+
+//encodeKeyValues returns the canonical encoding of the map. The keys are
+//sorted, and every key and value is prefixed by its length (uint32), so
+//different maps can't encode the same. An empty map encodes as nil.
+func encodeKeyValues(m map[string]string) (encoded []byte) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		encoded = appendLengthPrefixed(encoded, key)
+		encoded = appendLengthPrefixed(encoded, m[key])
+	}
+	return
+}
+
+//appendLengthPrefixed appends the string, prefixed by its length
+func appendLengthPrefixed(encoded []byte, s string) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(s)))
+	return append(append(encoded, length...), s...)
+}
+
+//decodeKeyValues decodes a map encoded by encodeKeyValues
+func decodeKeyValues(encoded []byte) (map[string]string, error) {
+	m := map[string]string{}
+	r := bytes.NewReader(encoded)
+	for r.Len() > 0 {
+		key, keyErr := readLengthPrefixed(r)
+		value, valueErr := readLengthPrefixed(r)
+		if keyErr != nil || valueErr != nil {
+			return nil, fmt.Errorf("%w: truncated key/value pairs",
+				ErrInvalidCiphertext)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+//readLengthPrefixed reads a string prefixed by its length
+func readLengthPrefixed(r *bytes.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if int64(length) > int64(r.Len()) {
+		return "", fmt.Errorf("length %d exceeds remaining bytes", length)
+	}
+	s := make([]byte, length)
+	_, err := r.Read(s)
+	return string(s), err
+}
@@ -0,0 +1,107 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func init() {
+	mustRegisterProvider("LOCAL", newLocalKms)
+}
+
+const (
+	//localKeyEnvVar holds a base64 encoded key, used when there's no key file
+	localKeyEnvVar = "MANTLE_LOCAL_KEY"
+	//localKeyLength is the length of the key-encryption key, i.e. AES-256
+	localKeyLength = 32
+)
+
+//localDekAdditionalData is authenticated alongside every DEK wrapped by
+//LocalKms
+var localDekAdditionalData = []byte("mantle LOCAL DEK")
+
+//LocalKms wraps DEKs with AES-256-GCM, using a key-encryption key held
+//locally rather than in a KMS, so no network is needed. It's intended for
+//development, tests and air-gapped builds, where keeping the key file safe is
+//up to you. The encrypted DEK is nonce[12]sealedDEK[48].
+type LocalKms struct {
+	//Key is the 256-bit key-encryption key
+	Key []byte
+}
+
+//newLocalKms creates a LocalKms using the key in the localKeyFile, or the
+//MANTLE_LOCAL_KEY environment variable
+func newLocalKms(opts Defaults) (KmsProvider, error) {
+	encodedKey := os.Getenv(localKeyEnvVar)
+	if opts.LocalKeyFile != "" {
+		keyFile, err := ioutil.ReadFile(opts.LocalKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encodedKey = string(keyFile)
+	}
+	if encodedKey == "" {
+		return nil, fmt.Errorf("a LOCAL key is required, in --localKeyFile or %s",
+			localKeyEnvVar)
+	}
+	key, err := decodeLocalKey(encodedKey)
+	return LocalKms{Key: key}, err
+}
+
+//decodeLocalKey decodes a base64 encoded key, as written by keygen
+func decodeLocalKey(encodedKey string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil || len(key) != localKeyLength {
+		return nil, fmt.Errorf("LOCAL key must be %d base64 encoded bytes",
+			localKeyLength)
+	}
+	return key, nil
+}
+
+//Name returns "LOCAL"
+func (l LocalKms) Name() string {
+	return "LOCAL"
+}
+
+//Encrypt wraps the DEK with the key
+func (l LocalKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte,
+	err error) {
+	nonce, err := randByteSlice(nonceLength)
+	if err == nil {
+		encryptedDek, err = cipherText(dek, l.Key, nonce, localDekAdditionalData,
+			true)
+	}
+	return append(nonce, encryptedDek...), kmsError(l.Name(), true, err)
+}
+
+//Decrypt unwraps the DEK with the key, failing authentication if it was
+//wrapped with a different key
+func (l LocalKms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte,
+	err error) {
+	if len(encryptedDek) < nonceLength {
+		err = errors.New("encrypted DEK is too short")
+	} else {
+		dek, err = cipherText(encryptedDek[nonceLength:], l.Key,
+			encryptedDek[:nonceLength], localDekAdditionalData, false)
+	}
+	return dek, kmsError(l.Name(), false, err)
+}
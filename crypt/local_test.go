@@ -0,0 +1,85 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//newTestLocalKms returns a LocalKms with a new key
+func newTestLocalKms(t *testing.T) LocalKms {
+	key, err := randByteSlice(localKeyLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return LocalKms{Key: key}
+}
+
+func TestLocalKms(t *testing.T) {
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, newTestLocalKms(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PlainTextFromPrimitives(sealed, newTestLocalKms(t), nil)
+	if !errors.Is(err, ErrAuthenticationFailed) || !errors.Is(err, ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v from the KMS provider", err, ErrAuthenticationFailed)
+	}
+}
+
+func TestLocalKeygen(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "mantle.key")
+	keygen := KeygenCommand{TargetFilepath: keyFile}
+	if err := keygen.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if fileInfo, _ := os.Stat(keyFile); fileInfo.Mode().Perm() != 0600 {
+		t.Errorf("Got key file mode %v, want 0600", fileInfo.Mode().Perm())
+	}
+	if err := keygen.Execute(nil); err == nil {
+		t.Error("Expected error overwriting the key file")
+	}
+}
+
+func TestNewLocalKms(t *testing.T) {
+	key, _ := NewLocalKey()
+	keyFile := filepath.Join(t.TempDir(), "mantle.key")
+	if err := ioutil.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := newLocalKms(Defaults{LocalKeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(localKeyEnvVar, key)
+	fromEnv, err := newLocalKms(Defaults{})
+	if err != nil || !bytes.Equal(fromFile.(LocalKms).Key, fromEnv.(LocalKms).Key) {
+		t.Errorf("Expected the same key from the file and environment (%v)", err)
+	}
+}
+
+func TestNewLocalKmsInvalidKey(t *testing.T) {
+	for _, key := range []string{"", "not base64", "c2hvcnQ="} {
+		t.Setenv(localKeyEnvVar, key)
+		if _, err := newLocalKms(Defaults{}); err == nil {
+			t.Errorf("Expected error for key %q", key)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//multiProviderName is the provider ID recorded in the header of a ciphertext
+//whose DEK is wrapped by several KMS keys
+const multiProviderName = "MULTI"
+
+//KeyRecipient is a KMS key to wrap the DEK with, given by the --key flag as
+//provider:keyName
+type KeyRecipient struct {
+	Provider string
+	KeyName  string
+}
+
+//UnmarshalFlag parses provider:keyName, the keyName may be omitted for
+//providers configured by other flags, e.g. LOCAL or AGE
+func (k *KeyRecipient) UnmarshalFlag(value string) error {
+	pair := strings.SplitN(value, ":", 2)
+	if pair[0] == "" {
+		return fmt.Errorf("expected provider:keyName for key, got %q", value)
+	}
+	*k = KeyRecipient{Provider: strings.ToUpper(pair[0])}
+	if len(pair) == 2 {
+		k.KeyName = pair[1]
+	}
+	return nil
+}
+
+//MultiKms wraps the DEK with every one of its providers' keys, so it can be
+//decrypted by any one of them, e.g. keys in different clouds for disaster
+//recovery. The encrypted DEK lists every wrapped DEK (see wrappedDek), which
+//are tried in order when decrypting.
+type MultiKms struct {
+	Providers []KmsProvider
+}
+
+//NewMultiKms creates a KmsProvider for each key, configured from the input
+//flags with the key's name. A MultiKms is returned for several keys, or the
+//key's own provider for one key.
+func NewMultiKms(keys []KeyRecipient, opts Defaults) (KmsProvider, error) {
+	var multi MultiKms
+	for _, key := range keys {
+		keyOpts := opts
+		if key.KeyName != "" {
+			keyOpts.KeyName = key.KeyName
+		}
+		kmsProvider, err := NewKmsProvider(key.Provider, keyOpts)
+		if err != nil {
+			return nil, err
+		}
+		multi.Providers = append(multi.Providers, kmsProvider)
+	}
+	if len(multi.Providers) == 1 {
+		return multi.Providers[0], nil
+	}
+	return multi, nil
+}
+
+//compositeKmsProvider is implemented by providers that wrap the DEK with
+//several other providers, any of which can then unwrap their part of it
+type compositeKmsProvider interface {
+	KmsProvider
+	providers() []KmsProvider
+}
+
+//providersFor returns the KmsProviders to unwrap the wrapped DEKs with, i.e.
+//those detected from the wrapped DEKs, or making up a compositeKmsProvider, or
+//just the KmsProvider itself
+func providersFor(kmsProvider KmsProvider,
+	wrapped []wrappedDek) ([]KmsProvider, error) {
+	switch kmsProvider := kmsProvider.(type) {
+	case detectingKmsProvider:
+		return kmsProvider.detectProviders(wrapped)
+	case compositeKmsProvider:
+		return kmsProvider.providers(), nil
+	}
+	return []KmsProvider{kmsProvider}, nil
+}
+
+//canDecrypt reports whether any of the providers can decrypt
+func (m MultiKms) canDecrypt() bool {
+	for _, kmsProvider := range m.Providers {
+		if canDecrypt(kmsProvider) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m MultiKms) providers() []KmsProvider {
+	return m.Providers
+}
+
+//Name returns "MULTI"
+func (m MultiKms) Name() string {
+	return multiProviderName
+}
+
+//Encrypt wraps the DEK with every provider, failing if any of them fails, as
+//the DEK must be recoverable with each key
+func (m MultiKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte,
+	err error) {
+	if len(m.Providers) == 0 {
+		return nil, kmsError(m.Name(), true, errors.New("no KMS keys given"))
+	}
+	for _, kmsProvider := range m.Providers {
+		w, err := wrapDek(ctx, kmsProvider, dek)
+		if err != nil {
+			return nil, err
+		}
+		encryptedDek = w.appendTo(encryptedDek)
+	}
+	return
+}
+
+//Decrypt tries each wrapped DEK in turn, with every provider it could have
+//been wrapped by, until one of them succeeds
+func (m MultiKms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte,
+	err error) {
+	wrapped, err := decodeWrappedDeks(encryptedDek)
+	if err != nil {
+		return
+	}
+	return unwrapAny(ctx, m.Providers, wrapped)
+}
+
+//wrappedDek is a DEK wrapped by one KMS key, along with the key's ID and the
+//encryption context it's bound to
+type wrappedDek struct {
+	providerID        string
+	keyID             string
+	encryptionContext map[string]string
+	encryptedDek      []byte
+}
+
+//wrapDek uses the KmsProvider to wrap the DEK, recording the key's ID and any
+//encryption context it's bound to
+func wrapDek(ctx context.Context, kmsProvider KmsProvider,
+	dek []byte) (w wrappedDek, err error) {
+	w.providerID = kmsProvider.Name()
+	if w.encryptedDek, err = kmsProvider.Encrypt(ctx, dek); err != nil {
+		return
+	}
+	if keyIDProvider, ok := kmsProvider.(keyIDKmsProvider); ok {
+		w.keyID = keyIDProvider.keyID()
+	}
+	if contextProvider, ok := kmsProvider.(encryptionContextKmsProvider); ok {
+		w.encryptionContext = contextProvider.encryptionContext()
+	}
+	return
+}
+
+//unwrapDek uses the KmsProvider to unwrap the DEK, replaying the encryption
+//context it was bound to
+func unwrapDek(ctx context.Context, kmsProvider KmsProvider,
+	w wrappedDek) ([]byte, error) {
+	if contextProvider, ok := kmsProvider.(encryptionContextKmsProvider); ok {
+		return contextProvider.decryptWithContext(ctx, w.encryptedDek,
+			w.encryptionContext)
+	}
+	if len(w.encryptionContext) > 0 {
+		return nil, fmt.Errorf("%w: KMS Provider %s doesn't support an encryption context",
+			ErrUnsupportedProvider, kmsProvider.Name())
+	}
+	return kmsProvider.Decrypt(ctx, w.encryptedDek)
+}
+
+//unwrapAny tries to unwrap each DEK in turn, with every provider of the same
+//name, returning the first DEK unwrapped. If none are, the error from every
+//attempt is returned.
+func unwrapAny(ctx context.Context, kmsProviders []KmsProvider,
+	wrapped []wrappedDek) ([]byte, error) {
+	var errs unwrapErrors
+	for _, w := range wrapped {
+		if dek, ok := w.unwrapWithAny(ctx, kmsProviders, &errs); ok {
+			return dek, nil
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil, fmt.Errorf("%w: CipherText was encrypted with KMS Provider %s, not %s",
+			ErrProviderMismatch, wrappedProviderIDs(wrapped), providerNames(kmsProviders))
+	case 1:
+		return nil, errs[0]
+	}
+	return nil, errs
+}
+
+//unwrapWithAny tries to unwrap the DEK with every provider of the same name,
+//recording their errors
+func (w wrappedDek) unwrapWithAny(ctx context.Context,
+	kmsProviders []KmsProvider, errs *unwrapErrors) ([]byte, bool) {
+	for _, kmsProvider := range kmsProviders {
+		if !strings.EqualFold(w.providerID, kmsProvider.Name()) {
+			continue
+		}
+		dek, err := unwrapDek(ctx, kmsProvider, w)
+		if err == nil {
+			return dek, true
+		}
+		*errs = append(*errs, err)
+	}
+	return nil, false
+}
+
+//wrappedProviderIDs returns the IDs of the providers that wrapped the DEKs,
+//e.g. AWS and GCP
+func wrappedProviderIDs(wrapped []wrappedDek) string {
+	names := make([]string, len(wrapped))
+	for i, w := range wrapped {
+		names[i] = w.providerID
+	}
+	return strings.Join(names, " and ")
+}
+
+//providerNames returns the names of the KmsProviders, e.g. AWS and GCP
+func providerNames(kmsProviders []KmsProvider) string {
+	names := make([]string, len(kmsProviders))
+	for i, kmsProvider := range kmsProviders {
+		names[i] = kmsProvider.Name()
+	}
+	return strings.Join(names, " and ")
+}
+
+//unwrapErrors records why every attempt to unwrap a DEK failed
+type unwrapErrors []error
+
+func (e unwrapErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+//Unwrap returns the error from the last attempt
+func (e unwrapErrors) Unwrap() error {
+	return e[len(e)-1]
+}
+
+//appendTo appends the wrapped DEK's encoding:
+//providerID keyID encryptionContext encryptedDEK
+//each prefixed by its length (uint32), the context encoded by encodeKeyValues
+func (w wrappedDek) appendTo(encoded []byte) []byte {
+	encoded = appendLengthPrefixed(encoded, w.providerID)
+	encoded = appendLengthPrefixed(encoded, w.keyID)
+	encoded = appendLengthPrefixed(encoded,
+		string(encodeKeyValues(w.encryptionContext)))
+	return appendLengthPrefixed(encoded, string(w.encryptedDek))
+}
+
+//decodeWrappedDeks decodes wrapped DEKs encoded by appendTo
+func decodeWrappedDeks(encoded []byte) (wrapped []wrappedDek, err error) {
+	r := bytes.NewReader(encoded)
+	for r.Len() > 0 {
+		var w wrappedDek
+		if w, err = readWrappedDek(r); err != nil {
+			return nil, err
+		}
+		wrapped = append(wrapped, w)
+	}
+	return
+}
+
+//readWrappedDek reads the next wrapped DEK
+func readWrappedDek(r *bytes.Reader) (w wrappedDek, err error) {
+	var fields [4]string
+	for i := range fields {
+		if fields[i], err = readLengthPrefixed(r); err != nil {
+			return w, fmt.Errorf("%w: truncated wrapped DEKs", ErrInvalidCiphertext)
+		}
+	}
+	w.providerID, w.keyID, w.encryptedDek = fields[0], fields[1], []byte(fields[3])
+	w.encryptionContext, err = decodeKeyValues([]byte(fields[2]))
+	return
+}
@@ -0,0 +1,172 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestKeyRecipientUnmarshalFlag(t *testing.T) {
+	tests := map[string]KeyRecipient{
+		"aws:arn:aws:kms:eu-west-1:111122223333:key/k": {"AWS",
+			"arn:aws:kms:eu-west-1:111122223333:key/k"},
+		"gcp:projects/p/locations/l/keyRings/r/cryptoKeys/k": {"GCP",
+			"projects/p/locations/l/keyRings/r/cryptoKeys/k"},
+		"local": {"LOCAL", ""},
+	}
+	for value, want := range tests {
+		var key KeyRecipient
+		if err := key.UnmarshalFlag(value); err != nil || key != want {
+			t.Errorf("Got %+v (%v), want %+v", key, err, want)
+		}
+	}
+	var key KeyRecipient
+	if err := key.UnmarshalFlag(":keyName"); err == nil {
+		t.Error("Expected error for a key without a provider")
+	}
+}
+
+func TestNewMultiKms(t *testing.T) {
+	opts := Defaults{KeyName: "ignored"}
+	single, err := NewMultiKms([]KeyRecipient{{"GCP", "gcpKey"}}, opts)
+	if err != nil || single.(GcpKms).KeyName != "gcpKey" {
+		t.Errorf("Expected a GcpKms for gcpKey (%v)", err)
+	}
+	multi, err := NewMultiKms([]KeyRecipient{{"AWS", "awsKey"},
+		{"GCP", "gcpKey"}}, opts)
+	if err != nil || providerNames(multi.(MultiKms).Providers) != "AWS and GCP" {
+		t.Errorf("Expected a MultiKms for AWS and GCP (%v)", err)
+	}
+	if _, err = NewMultiKms([]KeyRecipient{{"NOPE", ""}}, opts); !errors.Is(err,
+		ErrUnsupportedProvider) {
+		t.Errorf("Got %v, want %v", err, ErrUnsupportedProvider)
+	}
+}
+
+func TestMultiKms(t *testing.T) {
+	local, otherLocal := newTestLocalKms(t), newTestLocalKms(t)
+	identity := newTestAgeIdentity(t)
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, MultiKms{Providers: []KmsProvider{
+		local, AgeKms{Recipients: []age.Recipient{identity.Recipient()}}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypters := map[string]KmsProvider{
+		"first key":  local,
+		"second key": AgeKms{Identities: []age.Identity{identity}},
+		"every key": MultiKms{Providers: []KmsProvider{local,
+			AgeKms{Identities: []age.Identity{identity}}}},
+		"wrong key first": MultiKms{Providers: []KmsProvider{otherLocal, local}},
+	}
+	for name, decrypter := range decrypters {
+		result, err := PlainTextFromPrimitives(sealed, decrypter, nil)
+		if err != nil || !bytes.Equal(result, plaintext) {
+			t.Errorf("Decryption with %s failed (%v)", name, err)
+		}
+	}
+}
+
+func TestMultiKmsWrongKey(t *testing.T) {
+	sealed, err := sealWithNewDek([]byte("helloworld"), MultiKms{
+		Providers: []KmsProvider{newTestLocalKms(t), newTestLocalKms(t)}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = PlainTextFromPrimitives(sealed, newTestLocalKms(t),
+		nil); !errors.Is(err, ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v", err, ErrKMSUnavailable)
+	}
+	if _, err = PlainTextFromPrimitives(sealed, stubKms{}, nil); !errors.Is(err,
+		ErrProviderMismatch) {
+		t.Errorf("Got %v, want %v", err, ErrProviderMismatch)
+	}
+}
+
+func TestMultiKmsEncryptionContext(t *testing.T) {
+	encryptionContext := map[string]string{"service": "billing"}
+	encryptedDek, err := MultiKms{Providers: []KmsProvider{newTestLocalKms(t),
+		contextKms{context: encryptionContext}}}.Encrypt(context.Background(), make([]byte, dekLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := decodeWrappedDeks(encryptedDek)
+	if err != nil || len(wrapped) != 2 || !reflect.DeepEqual(
+		wrapped[1].encryptionContext, encryptionContext) {
+		t.Fatalf("Expected the second wrapped DEK to record its context (%v)", err)
+	}
+	if _, err = (MultiKms{Providers: []KmsProvider{contextKms{}}}).Decrypt(context.Background(),
+		encryptedDek); err != nil {
+		t.Errorf("Expected the wrapped DEK's context to be replayed (%v)", err)
+	}
+}
+
+func TestDecodeWrappedDeksTruncated(t *testing.T) {
+	encoded := wrappedDek{providerID: "STUB", encryptedDek: []byte("dek")}.appendTo(nil)
+	for i := 1; i < len(encoded); i++ {
+		if _, err := decodeWrappedDeks(encoded[:i]); !errors.Is(err,
+			ErrInvalidCiphertext) {
+			t.Errorf("Got %v, want %v", err, ErrInvalidCiphertext)
+		}
+	}
+}
+
+func TestMultiKmsEncryptFails(t *testing.T) {
+	_, err := sealWithNewDek([]byte("helloworld"), MultiKms{Providers: []KmsProvider{
+		newTestLocalKms(t), AgeKms{}}}, nil)
+	if !errors.Is(err, ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v when a key fails", err, ErrKMSUnavailable)
+	}
+}
+
+func TestMultiKmsStream(t *testing.T) {
+	local, identity := newTestLocalKms(t), newTestAgeIdentity(t)
+	plaintext, _ := randByteSlice(2*defaultChunkSize + 1)
+	var cipherBuf bytes.Buffer
+	err := EncryptStream(&cipherBuf, bytes.NewReader(plaintext), true,
+		MultiKms{Providers: []KmsProvider{local,
+			AgeKms{Recipients: []age.Recipient{identity.Recipient()}}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plainBuf bytes.Buffer
+	err = DecryptStream(&plainBuf, &cipherBuf,
+		AgeKms{Identities: []age.Identity{identity}}, nil)
+	if err != nil || !bytes.Equal(plainBuf.Bytes(), plaintext) {
+		t.Errorf("Decryption with the second key failed (%v)", err)
+	}
+}
+
+func TestGetKmsProviderWithKeys(t *testing.T) {
+	keys, kmsProvider := defaultOptions.Keys, defaultOptions.KMSProvider
+	t.Cleanup(func() {
+		defaultOptions.Keys, defaultOptions.KMSProvider = keys, kmsProvider
+	})
+	defaultOptions.Keys = []KeyRecipient{{"AWS", "awsKey"}, {"GCP", "gcpKey"}}
+	defaultOptions.KMSProvider = ""
+	if p, err := getKmsProvider(defaultOptions.KMSProvider); err != nil ||
+		p.Name() != multiProviderName {
+		t.Errorf("Expected a MultiKms (%v)", err)
+	}
+	if _, err := getKmsProvider("AWS"); err == nil {
+		t.Error("Expected error using -m with --key")
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build pkcs11
+
+package crypt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+)
+
+func init() {
+	mustRegisterProvider("PKCS11", newPkcs11Kms)
+}
+
+//pkcs11DekAdditionalData is authenticated alongside every DEK wrapped by
+//Pkcs11Kms
+var pkcs11DekAdditionalData = []byte("mantle PKCS11 DEK")
+
+//Pkcs11Kms wraps DEKs with AES-GCM, using an AES key held in an HSM, or any
+//other token, through its PKCS#11 module. The encrypted DEK is
+//iv[12]sealedDEK[48]. It's only built with the pkcs11 build tag, as it needs
+//cgo.
+type Pkcs11Kms struct {
+	//Module is the path of the PKCS#11 module, e.g. SoftHSM's libsofthsm2.so
+	Module string
+	//Slot is the ID of the slot holding the token
+	Slot uint
+	//Pin is the user PIN, used to log in to the token
+	Pin string
+	//KeyLabel is the CKA_LABEL of the AES key
+	KeyLabel string
+}
+
+//newPkcs11Kms creates a Pkcs11Kms from the input flags, using the keyName as
+//the key label
+func newPkcs11Kms(opts Defaults) (KmsProvider, error) {
+	if opts.Pkcs11Module == "" || opts.KeyName == "" {
+		return nil, errors.New("the PKCS#11 module (--pkcs11Module) and key " +
+			"label (-n,--keyName) are required")
+	}
+	return Pkcs11Kms{
+		Module:   opts.Pkcs11Module,
+		Slot:     opts.Pkcs11Slot,
+		Pin:      opts.Pkcs11Pin,
+		KeyLabel: opts.KeyName,
+	}, nil
+}
+
+func (p Pkcs11Kms) keyID() string {
+	return p.KeyLabel
+}
+
+//checkKeyID succeeds, as the KeyLabel only selects a key on the configured
+//token
+func (p Pkcs11Kms) checkKeyID() error {
+	return nil
+}
+
+//Name returns "PKCS11"
+func (p Pkcs11Kms) Name() string {
+	return "PKCS11"
+}
+
+//Encrypt wraps the DEK with the key
+func (p Pkcs11Kms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte,
+	err error) {
+	iv, err := randByteSlice(nonceLength)
+	if err == nil {
+		encryptedDek, err = p.gcm(iv, dek, true)
+	}
+	return append(iv, encryptedDek...), kmsError(p.Name(), true, err)
+}
+
+//Decrypt unwraps the DEK with the key
+func (p Pkcs11Kms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte,
+	err error) {
+	if len(encryptedDek) < nonceLength {
+		err = errors.New("encrypted DEK is too short")
+	} else {
+		dek, err = p.gcm(encryptedDek[:nonceLength], encryptedDek[nonceLength:],
+			false)
+	}
+	return dek, kmsError(p.Name(), false, err)
+}
+
+//gcm encrypts or decrypts the data with the key, using CKM_AES_GCM
+func (p Pkcs11Kms) gcm(iv, data []byte, encrypt bool) (result []byte, err error) {
+	params := pkcs11.NewGCMParams(iv, pkcs11DekAdditionalData, 128)
+	defer params.Free()
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	err = p.withKey(func(c *pkcs11.Ctx, session pkcs11.SessionHandle,
+		key pkcs11.ObjectHandle) error {
+		if encrypt {
+			if err := c.EncryptInit(session, mechanism, key); err != nil {
+				return err
+			}
+			result, err = c.Encrypt(session, data)
+			return err
+		}
+		if err := c.DecryptInit(session, mechanism, key); err != nil {
+			return err
+		}
+		result, err = c.Decrypt(session, data)
+		return err
+	})
+	return
+}
+
+//withKey loads the module, and logs in to the token, calling f with the
+//session and the key
+func (p Pkcs11Kms) withKey(f func(*pkcs11.Ctx, pkcs11.SessionHandle,
+	pkcs11.ObjectHandle) error) error {
+	c := pkcs11.New(p.Module)
+	if c == nil {
+		return fmt.Errorf("couldn't load PKCS#11 module %s", p.Module)
+	}
+	defer c.Destroy()
+	if err := c.Initialize(); err != nil {
+		return err
+	}
+	defer c.Finalize()
+	session, err := c.OpenSession(p.Slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return err
+	}
+	defer c.CloseSession(session)
+	if err = c.Login(session, pkcs11.CKU_USER, p.Pin); err != nil {
+		return err
+	}
+	defer c.Logout(session)
+	key, err := findPkcs11Key(c, session, p.KeyLabel)
+	if err != nil {
+		return err
+	}
+	return f(c, session, key)
+}
+
+//findPkcs11Key returns the secret key with the label, which must be unique
+func findPkcs11Key(c *pkcs11.Ctx, session pkcs11.SessionHandle,
+	label string) (pkcs11.ObjectHandle, error) {
+	err := c.FindObjectsInit(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer c.FindObjectsFinal(session)
+	keys, _, err := c.FindObjects(session, 2)
+	switch {
+	case err != nil:
+		return 0, err
+	case len(keys) == 0:
+		return 0, fmt.Errorf("no secret key labelled %q", label)
+	case len(keys) > 1:
+		return 0, fmt.Errorf("more than one secret key labelled %q", label)
+	}
+	return keys[0], nil
+}
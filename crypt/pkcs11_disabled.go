@@ -0,0 +1,30 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pkcs11
+
+package crypt
+
+import "errors"
+
+func init() {
+	mustRegisterProvider("PKCS11", newPkcs11Kms)
+}
+
+//newPkcs11Kms fails, as the PKCS11 provider needs cgo, so is only built with
+//the pkcs11 build tag
+func newPkcs11Kms(opts Defaults) (KmsProvider, error) {
+	return nil, errors.New("mantle was built without PKCS11 support, " +
+		"rebuild it with: go build -tags pkcs11")
+}
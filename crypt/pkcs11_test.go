@@ -0,0 +1,119 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build pkcs11
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+//newTestPkcs11Kms returns a Pkcs11Kms using a new AES key, in the token given
+//by the MANTLE_PKCS11_MODULE, MANTLE_PKCS11_SLOT (or the first slot with a
+//token) and MANTLE_PKCS11_PIN environment variables, e.g. a SoftHSM token.
+//The key is destroyed when the test finishes.
+func newTestPkcs11Kms(t *testing.T) Pkcs11Kms {
+	p := Pkcs11Kms{Module: os.Getenv("MANTLE_PKCS11_MODULE"),
+		Pin: os.Getenv("MANTLE_PKCS11_PIN")}
+	if p.Module == "" {
+		t.Skip("MANTLE_PKCS11_MODULE isn't set")
+	}
+	label, _ := randByteSlice(8)
+	p.KeyLabel = "mantle-test-" + hex.EncodeToString(label)
+	c, session := openTestPkcs11Session(t, &p)
+	key, err := c.GenerateKey(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.KeyLabel),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.DestroyObject(session, key) })
+	return p
+}
+
+//openTestPkcs11Session opens a logged in session, setting the slot if it's
+//not in the environment. It's closed when the test finishes.
+func openTestPkcs11Session(t *testing.T, p *Pkcs11Kms) (*pkcs11.Ctx,
+	pkcs11.SessionHandle) {
+	c := pkcs11.New(p.Module)
+	if c == nil || c.Initialize() != nil {
+		t.Fatalf("Couldn't load PKCS#11 module %s", p.Module)
+	}
+	p.Slot = testPkcs11Slot(c)
+	session, err := c.OpenSession(p.Slot,
+		pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err == nil {
+		err = c.Login(session, pkcs11.CKU_USER, p.Pin)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Logout(session)
+		c.CloseSession(session)
+		c.Finalize()
+		c.Destroy()
+	})
+	return c, session
+}
+
+//testPkcs11Slot returns the slot in MANTLE_PKCS11_SLOT, or the first slot
+//with a token, as SoftHSM assigns slot IDs when initialising tokens
+func testPkcs11Slot(c *pkcs11.Ctx) uint {
+	slot, err := strconv.ParseUint(os.Getenv("MANTLE_PKCS11_SLOT"), 10, 0)
+	if slots, _ := c.GetSlotList(true); err != nil && len(slots) > 0 {
+		return slots[0]
+	}
+	return uint(slot)
+}
+
+func TestPkcs11Kms(t *testing.T) {
+	p := newTestPkcs11Kms(t)
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := PlainTextFromPrimitives(sealed, p, nil)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Decryption failed (%v)", err)
+	}
+	_, err = PlainTextFromPrimitives(sealed, newTestPkcs11Kms(t), nil)
+	if !errors.Is(err, ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v from another key", err, ErrKMSUnavailable)
+	}
+}
+
+func TestPkcs11KmsMissingKey(t *testing.T) {
+	p := newTestPkcs11Kms(t)
+	p.KeyLabel += "-missing"
+	if _, err := p.Encrypt(context.Background(), []byte("dek")); err == nil {
+		t.Error("Expected error encrypting with a missing key")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//KmsProvider encrypts and decrypts DEKs using a key held by a key management
+//service
+type KmsProvider interface {
+	//Name returns the identifier recorded in ciphertext headers, which should
+	//be the name the provider is registered under
+	Name() string
+	//Encrypt encrypts a DEK
+	Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte, err error)
+	//Decrypt decrypts a DEK previously encrypted by Encrypt
+	Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte, err error)
+}
+
+//legacyKmsProvider is implemented by providers that predate the ciphertext
+//header, and so may need to decrypt headerless ciphertexts
+type legacyKmsProvider interface {
+	KmsProvider
+	encryptedDekLength() int
+}
+
+//encryptionContextKmsProvider is implemented by providers that bind an
+//encryption context to the DEK when encrypting it. The context is stored in
+//the ciphertext header, and replayed when decrypting.
+type encryptionContextKmsProvider interface {
+	KmsProvider
+	//encryptionContext returns the context Encrypt binds to the DEK
+	encryptionContext() map[string]string
+	//decryptWithContext decrypts a DEK encrypted with the context
+	decryptWithContext(ctx context.Context, encryptedDek []byte,
+		encryptionContext map[string]string) (dek []byte, err error)
+}
+
+//keyIDKmsProvider is implemented by providers that identify the key they
+//encrypt DEKs with, e.g. an AWS key ARN, recorded in the ciphertext header so
+//it can be decrypted without being told the key
+type keyIDKmsProvider interface {
+	KmsProvider
+	//keyID returns the identifier of the key, given as the keyName to the
+	//provider's factory to decrypt with the same key
+	keyID() string
+	//checkKeyID checks the keyName the provider was created with only
+	//identifies a key where the provider's configured to send requests (and
+	//credentials), as a key ID recorded in a ciphertext can't be trusted
+	checkKeyID() error
+}
+
+//decryptingKmsProvider is implemented by providers that may be configured
+//only to encrypt, e.g. age given recipients but no identity, so what they
+//encrypt can't be validated
+type decryptingKmsProvider interface {
+	KmsProvider
+	//canDecrypt reports whether the provider is configured to decrypt
+	canDecrypt() bool
+}
+
+//canDecrypt reports whether the KmsProvider can decrypt what it encrypts
+func canDecrypt(kmsProvider KmsProvider) bool {
+	decryptingProvider, ok := kmsProvider.(decryptingKmsProvider)
+	return !ok || decryptingProvider.canDecrypt()
+}
+
+//detectingKmsProvider is implemented by providers that create the providers
+//to decrypt with from what's recorded in the ciphertext, see DetectedKms
+type detectingKmsProvider interface {
+	KmsProvider
+	//detectProviders returns providers for the wrapped DEKs' keys
+	detectProviders(wrapped []wrappedDek) ([]KmsProvider, error)
+	//detectLegacyProvider returns the provider a legacy (headerless)
+	//ciphertext was most likely encrypted with
+	detectLegacyProvider(cipherBytes []byte) (KmsProvider, error)
+}
+
+//ProviderFactory creates a KmsProvider, configured from the input flags
+type ProviderFactory func(opts Defaults) (KmsProvider, error)
+
+//defaultProvider is used when no KMS provider is specified, as GCP was the
+//only provider when mantle was first released
+const defaultProvider = "GCP"
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderFactory{}
+)
+
+//RegisterProvider makes a KmsProvider available by name, e.g. to the
+//'-m,--kmsProvider' flag. Names are case-insensitive.
+func RegisterProvider(name string, factory ProviderFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("KMS provider name and factory are required")
+	}
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	name = strings.ToUpper(name)
+	if _, ok := providers[name]; ok {
+		return fmt.Errorf("KMS provider %v is already registered", name)
+	}
+	providers[name] = factory
+	return nil
+}
+
+//Providers returns the names of every registered KmsProvider, sorted
+func Providers() (names []string) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+//NewKmsProvider creates the named KmsProvider, using its registered factory
+func NewKmsProvider(name string, opts Defaults) (KmsProvider, error) {
+	if name == "" {
+		name = defaultProvider
+	}
+	providersMu.RLock()
+	factory, ok := providers[strings.ToUpper(name)]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedProvider, name)
+	}
+	return factory(opts)
+}
+
+//mustRegisterProvider registers a built-in KmsProvider, panicking if the
+//name's already taken
+func mustRegisterProvider(name string, factory ProviderFactory) {
+	if err := RegisterProvider(name, factory); err != nil {
+		panic(err)
+	}
+}
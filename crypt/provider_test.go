@@ -0,0 +1,114 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newStubKms(opts Defaults) (KmsProvider, error) {
+	return stubKms{}, nil
+}
+
+func TestRegisterProvider(t *testing.T) {
+	if err := RegisterProvider("registered", newStubKms); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterProvider("REGISTERED", newStubKms); err == nil {
+		t.Error("Expected error registering a provider twice")
+	}
+	kmsProvider, err := NewKmsProvider("Registered", Defaults{})
+	if err != nil || kmsProvider.Name() != "STUB" {
+		t.Errorf("Got %v (%v), want the stub provider", kmsProvider, err)
+	}
+}
+
+var invalidRegistrationTests = []struct {
+	name    string
+	factory ProviderFactory
+}{
+	{"", newStubKms},
+	{"nofactory", nil},
+}
+
+func TestRegisterInvalidProvider(t *testing.T) {
+	for _, test := range invalidRegistrationTests {
+		if err := RegisterProvider(test.name, test.factory); err == nil {
+			t.Errorf("Expected error registering provider %q", test.name)
+		}
+	}
+}
+
+func TestNewKmsProviderDefault(t *testing.T) {
+	kmsProvider, err := NewKmsProvider("", Defaults{})
+	if err != nil || kmsProvider.Name() != defaultProvider {
+		t.Errorf("Got %v (%v), want %s", kmsProvider, err, defaultProvider)
+	}
+	if _, err = NewKmsProvider("blah", Defaults{}); !errors.Is(err, ErrUnsupportedProvider) {
+		t.Errorf("Got %v, want %v", err, ErrUnsupportedProvider)
+	}
+}
+
+func TestGcpKeyName(t *testing.T) {
+	opts := Defaults{ProjectID: "p", LocationID: "l", KeyRingID: "r",
+		CryptoKeyID: "k"}
+	kmsProvider, _ := newGcpKms(opts)
+	want := "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	if kmsProvider.(GcpKms).KeyName != want {
+		t.Errorf("Got %s, want %s", kmsProvider.(GcpKms).KeyName, want)
+	}
+}
+
+func TestKmsEndpoint(t *testing.T) {
+	opts := Defaults{KeyName: "k", KMSEndpoint: "http://all",
+		GcpKMSEndpoint: "http://gcp"}
+	kmsProvider, _ := newGcpKms(opts)
+	if endpoint := kmsProvider.(GcpKms).Endpoint; endpoint != "http://gcp" {
+		t.Errorf("Got endpoint %s, want http://gcp", endpoint)
+	}
+	kmsProvider, _ = newAwsKms(opts)
+	if endpoint := kmsProvider.(AwsKms).Endpoint; endpoint != "http://all" {
+		t.Errorf("Got endpoint %s, want http://all", endpoint)
+	}
+	// Vault has its own address, so isn't sent to the --kmsEndpoint
+	t.Setenv("VAULT_ADDR", "")
+	kmsProvider, _ = newVaultKms(opts)
+	if address := kmsProvider.(VaultKms).Address; address != "" {
+		t.Errorf("Got address %s, want the default", address)
+	}
+}
+
+func TestGcpEndpoint(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		path = r.URL.Path
+		json.NewEncoder(w).Encode(map[string]string{"ciphertext": "ZGVr"})
+	}))
+	defer server.Close()
+	encryptedDek, err := GcpKms{KeyName: "projects/p/cryptoKeys/k",
+		Endpoint: server.URL}.Encrypt(context.Background(), []byte("dek"))
+	if err != nil || string(encryptedDek) != "dek" {
+		t.Fatalf("Got %s (%v), want dek", encryptedDek, err)
+	}
+	if want := "/v1/projects/p/cryptoKeys/k:encrypt"; path != want {
+		t.Errorf("Got path %s, want %s", path, want)
+	}
+}
@@ -16,6 +16,9 @@ package crypt
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 )
 
 func init() {
@@ -29,7 +32,7 @@ func init() {
 //ReencryptCommand type
 type ReencryptCommand struct {
 	DisableValidation bool   `short:"d" long:"disableValidation" description:"Disable validation of ciphertext"`
-	Filepath          string `short:"f" long:"filepath" description:"Path of file to get encrypted string from" default:"./cipher.txt"`
+	Filepath          string `short:"f" long:"filepath" description:"Path of file to reencrypt in place" default:"./cipher.txt"`
 	SingleLine        bool   `short:"s" long:"singleLine" description:"Disable use of newline chars in ciphertext"`
 }
 
@@ -41,10 +44,89 @@ func (x *ReencryptCommand) Execute(args []string) error {
 	return Reencrypt(x.Filepath, x.SingleLine, x.DisableValidation)
 }
 
-//Reencrypt decrypts into a plaintext byte array, and encrypts back to ciphertext file
+//Reencrypt decrypts into a plaintext byte array, and encrypts back to
+//ciphertext file, atomically replacing it. Ciphertext files larger than the
+//StreamingThreshold are streamed instead.
 func Reencrypt(filepath string, singleLine, disableValidation bool) error {
+	fileInfo, err := os.Stat(filepath)
+	if err != nil {
+		return err
+	}
+	if defaultOptions.Format != "" {
+		return reencryptStructured(filepath, disableValidation)
+	}
+	if fileInfo.Size() > StreamingThreshold {
+		return reencryptStream(filepath, fileInfo.Mode().Perm(), singleLine,
+			disableValidation)
+	}
 	plaintext, err := PlainText(filepath)
-	check(err)
-	err = CipherText(plaintext, filepath, singleLine, disableValidation)
+	if err != nil {
+		return err
+	}
+	return CipherText(plaintext, filepath, singleLine, disableValidation)
+}
+
+//reencryptStructured decrypts the values of the structured file in memory,
+//and encrypts them again, atomically replacing it
+func reencryptStructured(filepath string, disableValidation bool) error {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	decryptProvider, err := getDecryptKmsProvider()
+	if err != nil {
+		return err
+	}
+	kmsProvider, err := getKmsProvider(defaultOptions.KMSProvider)
+	if err != nil {
+		return err
+	}
+	plaintext, err := DecryptStructured(data, defaultOptions.Format,
+		decryptProvider, defaultOptions.AAD)
+	defer zeroBytes(plaintext)
+	if err != nil {
+		return err
+	}
+	return writeStructured(filepath, plaintext, reencryptKeyRegex(data),
+		disableValidation, kmsProvider)
+}
+
+//reencryptKeyRegex returns the --keyRegex, or else the key regex the
+//structured file was encrypted with
+func reencryptKeyRegex(data []byte) string {
+	if defaultOptions.KeyRegex != "" {
+		return defaultOptions.KeyRegex
+	}
+	keyRegex, _ := structuredKeyRegex(data, defaultOptions.Format)
+	return keyRegex
+}
+
+//reencryptStream decrypts the ciphertext file a chunk at a time, piping the
+//plaintext into a new ciphertext that atomically replaces the file
+func reencryptStream(filepath string, perm os.FileMode, singleLine,
+	disableValidation bool) error {
+	decryptProvider, err := getDecryptKmsProvider()
+	if err != nil {
+		return err
+	}
+	kmsProvider, err := getKmsProvider(defaultOptions.KMSProvider)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(filepath, perm, func(w io.Writer) error {
+		plaintextReader, plaintextWriter := io.Pipe()
+		go func() {
+			plaintextWriter.CloseWithError(decryptFile(filepath, plaintextWriter,
+				decryptProvider))
+		}()
+		err := encryptAndValidate(w, plaintextReader, singleLine,
+			disableValidation, kmsProvider, defaultOptions.AAD)
+		plaintextReader.CloseWithError(err)
+		return err
+	})
+	if err == nil {
+		fmt.Fprintf(statusOutput, "Reencryption successful, ciphertext available at %s\n",
+			filepath)
+	}
 	return err
 }
@@ -0,0 +1,67 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//useStubProvider makes the stub provider the default for the test
+func useStubProvider(t *testing.T) {
+	RegisterProvider("STUB", newStubKms)
+	kmsProvider := defaultOptions.KMSProvider
+	defaultOptions.KMSProvider = "STUB"
+	t.Cleanup(func() { defaultOptions.KMSProvider = kmsProvider })
+}
+
+func TestReencryptInPlace(t *testing.T) {
+	useStubProvider(t)
+	streamingThreshold := StreamingThreshold
+	defer func() { StreamingThreshold = streamingThreshold }()
+	dir, err := ioutil.TempDir("", "mantle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prod.enc")
+	for _, threshold := range []int64{streamingThreshold, 0} {
+		StreamingThreshold = threshold
+		reencryptAndCheck(t, path)
+	}
+}
+
+//reencryptAndCheck encrypts a new plaintext to the path, reencrypts it and
+//checks it's been replaced by a new ciphertext of the same plaintext
+func reencryptAndCheck(t *testing.T, path string) {
+	plaintext, _ := randByteSlice(3 * defaultChunkSize)
+	if err := CipherText(plaintext, path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := ioutil.ReadFile(path)
+	if err := Reencrypt(path, false, false); err != nil {
+		t.Fatal(err)
+	}
+	reencrypted, _ := ioutil.ReadFile(path)
+	result, err := PlainText(path)
+	if bytes.Equal(original, reencrypted) || err != nil ||
+		!bytes.Equal(result, plaintext) {
+		t.Errorf("Reencrypting with threshold %d failed (%v)",
+			StreamingThreshold, err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"errors"
+)
+
+//Shamir's secret sharing over GF(2^8), splitting each byte of a secret
+//separately, with the AES field polynomial x^8+x^4+x^3+x+1. Each share is the
+//same length as the secret, and is the value at x (1-255) of random
+//polynomials of degree threshold-1, whose value at 0 is the secret.
+
+//gfExp and gfLog are exponent and logarithm tables for the generator 3
+var gfExp, gfLog [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		//multiply by the generator, i.e. x*2 xor x
+		x ^= x<<1 ^ byte(int8(x)>>7)&0x1b
+	}
+	gfExp[255] = gfExp[0]
+}
+
+//gfMul multiplies in GF(2^8)
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+//gfDiv divides in GF(2^8), b must not be 0
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])-int(gfLog[b])+255)%255]
+}
+
+//splitSecret splits the secret into n shares, any threshold of which can
+//recover it. Share i is the value at x = i+1.
+func splitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, errors.New("the threshold must be 2 or more, and no more " +
+			"than the number of shares, which can't exceed 255")
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	return shares, splitBytes(secret, shares, threshold)
+}
+
+//splitBytes sets every byte of every share
+func splitBytes(secret []byte, shares [][]byte, threshold int) error {
+	for b := range secret {
+		if err := splitByte(secret, b, shares, threshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//splitByte sets byte b of every share, from a new random polynomial for byte
+//b of the secret
+func splitByte(secret []byte, b int, shares [][]byte, threshold int) error {
+	//coefficients of x^1 to x^threshold-1, x^0 is the secret byte
+	coefficients, err := randByteSlice(threshold - 1)
+	if err != nil {
+		return err
+	}
+	for i, share := range shares {
+		share[b] = evaluatePolynomial(secret[b], coefficients, byte(i+1))
+	}
+	return nil
+}
+
+//evaluatePolynomial returns the value at x of the polynomial with the
+//constant term and coefficients, using Horner's method
+func evaluatePolynomial(constant byte, coefficients []byte, x byte) (y byte) {
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return gfMul(y, x) ^ constant
+}
+
+//combineShares recovers the secret from threshold shares, by Lagrange
+//interpolation at 0. The xs are the shares' distinct, non-zero x values.
+func combineShares(xs []byte, shares [][]byte) []byte {
+	secret := make([]byte, len(shares[0]))
+	for j, share := range shares {
+		basis := lagrangeBasisAtZero(xs, j)
+		for b := range secret {
+			secret[b] ^= gfMul(share[b], basis)
+		}
+	}
+	return secret
+}
+
+//lagrangeBasisAtZero returns the jth Lagrange basis polynomial at 0, i.e. the
+//product of x_m / (x_m - x_j) for every m != j, where subtraction is xor
+func lagrangeBasisAtZero(xs []byte, j int) byte {
+	basis := byte(1)
+	for m, x := range xs {
+		if m != j {
+			basis = gfMul(basis, gfDiv(x, x^xs[j]))
+		}
+	}
+	return basis
+}
@@ -0,0 +1,83 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+//slowGfMul multiplies in GF(2^8) bit by bit, to check the tables against
+func slowGfMul(a, b byte) (product byte) {
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			product ^= a
+		}
+		a = a<<1 ^ byte(int8(a)>>7)&0x1b
+	}
+	return
+}
+
+func TestGfMulDiv(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			product := gfMul(byte(a), byte(b))
+			if product != slowGfMul(byte(a), byte(b)) || gfDiv(product,
+				byte(b)) != byte(a) {
+				t.Fatalf("Wrong product or quotient for %d and %d", a, b)
+			}
+		}
+	}
+}
+
+func TestSplitCombineSecret(t *testing.T) {
+	secret, _ := randByteSlice(dekLength)
+	for threshold := 2; threshold <= 5; threshold++ {
+		shares, err := splitSecret(secret, 5, threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//every run of threshold shares, wrapping around
+		for first := range shares {
+			xs, subset := shareSubset(shares, first, threshold)
+			if !bytes.Equal(combineShares(xs, subset), secret) {
+				t.Errorf("%d shares from %d didn't recover the secret", threshold,
+					first+1)
+			}
+			xs, subset = shareSubset(shares, first, threshold-1)
+			if bytes.Equal(combineShares(xs, subset), secret) {
+				t.Errorf("%d shares recovered the secret", threshold-1)
+			}
+		}
+	}
+}
+
+//shareSubset returns n shares starting at first, along with their x values
+func shareSubset(shares [][]byte, first, n int) (xs []byte, subset [][]byte) {
+	for i := 0; i < n; i++ {
+		x := (first + i) % len(shares)
+		xs, subset = append(xs, byte(x+1)), append(subset, shares[x])
+	}
+	return
+}
+
+func TestSplitSecretInvalid(t *testing.T) {
+	for _, test := range [][2]int{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err := splitSecret([]byte("secret"), test[0], test[1]); err == nil {
+			t.Errorf("Expected error splitting into %d shares with threshold %d",
+				test[0], test[1])
+		}
+	}
+}
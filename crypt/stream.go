@@ -0,0 +1,271 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+//Streamed ciphertexts split the plaintext into fixed-size chunks, each sealed
+//with AES-GCM using a nonce made up of:
+//
+//	noncePrefix[7]chunkCounter[4]finalChunkFlag[1]
+//
+//The random nonce prefix is stored in the header, the counter (big-endian)
+//stops chunks being reordered, and the flag stops the ciphertext being
+//truncated at a chunk boundary. Every chunk is also authenticated alongside the
+//header.
+const (
+	defaultChunkSize        = 64 * 1024
+	maxChunkSize            = 16 * 1024 * 1024
+	streamNoncePrefixLength = 7
+	gcmTagLength            = 16
+)
+
+//StreamingThreshold is the plaintext size, in bytes, above which the encrypt
+//command streams the plaintext rather than reading it all into memory
+var StreamingThreshold int64 = 64 * 1024 * 1024
+
+//NewEncryptWriter returns a WriteCloser that encrypts everything written to it
+//in chunks, using a new DEK encrypted by the KmsProvider, and writes the
+//header and encrypted chunks to w. Close must be called to write the final
+//chunk, it doesn't close w. The AAD (which may be nil) is authenticated with
+//every chunk.
+func NewEncryptWriter(w io.Writer, kmsProvider KmsProvider,
+	aad AAD) (io.WriteCloser, error) {
+	dek, err := randByteSlice(dekLength)
+	if err != nil {
+		return nil, err
+	}
+	noncePrefix, err := randByteSlice(streamNoncePrefixLength)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newHeader(kmsProvider, dek, noncePrefix, aad)
+	if err != nil {
+		return nil, err
+	}
+	h.chunkSize = defaultChunkSize
+	return newEncryptWriter(w, h, dek, aad)
+}
+
+//newEncryptWriter writes the header to w, and returns a writer encrypting
+//chunks with the DEK
+func newEncryptWriter(w io.Writer, h header, dek []byte,
+	aad AAD) (*encryptWriter, error) {
+	aesgcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	rawHeader, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(rawHeader); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:              w,
+		aesgcm:         aesgcm,
+		noncePrefix:    h.nonce,
+		additionalData: additionalData(rawHeader, aad),
+		buf:            make([]byte, 0, h.chunkSize),
+		sealed:         make([]byte, 0, int(h.chunkSize)+gcmTagLength),
+	}, nil
+}
+
+//encryptWriter buffers plaintext until it has a full chunk to encrypt. A full
+//chunk is only sealed once more plaintext is written, as the final chunk is
+//sealed differently.
+type encryptWriter struct {
+	w              io.Writer
+	aesgcm         cipher.AEAD
+	noncePrefix    []byte
+	additionalData []byte
+	counter        uint32
+	buf            []byte
+	sealed         []byte
+	closed         bool
+}
+
+//Write encrypts p
+func (e *encryptWriter) Write(p []byte) (n int, err error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	for len(p) > 0 {
+		if len(e.buf) == cap(e.buf) {
+			if err = e.sealChunk(false); err != nil {
+				return
+			}
+		}
+		copied := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+copied]
+		p = p[copied:]
+		n += copied
+	}
+	return
+}
+
+//Close seals and writes the final chunk
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.sealChunk(true)
+}
+
+//sealChunk seals the buffered plaintext and writes it
+func (e *encryptWriter) sealChunk(final bool) error {
+	if e.counter == math.MaxUint32 {
+		return errors.New("plaintext too large to encrypt")
+	}
+	nonce := chunkNonce(e.noncePrefix, e.counter, final)
+	e.sealed = e.aesgcm.Seal(e.sealed[:0], nonce, e.buf, e.additionalData)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+//NewDecryptReader returns a Reader of the plaintext decrypted from r, using
+//the KmsProvider to decrypt the DEK, and the AAD it was encrypted with.
+//Streamed ciphertexts are decrypted a chunk at a time, other ciphertexts are
+//read into memory and decrypted in one go. A streamed ciphertext that's been
+//tampered with or truncated returns an error once the affected chunk is read,
+//so plaintext read before then mustn't be trusted until the Reader returns
+//io.EOF.
+func NewDecryptReader(r io.Reader, kmsProvider KmsProvider,
+	aad AAD) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(magicLength); !hasHeader(magic) {
+		return decryptAll(br, kmsProvider, aad)
+	}
+	h, rawHeader, err := readHeader(br)
+	if err != nil || h.chunkSize == 0 {
+		// a ciphertext with an invalid header may be a legacy one, see
+		// PlainTextFromPrimitives
+		return decryptAll(io.MultiReader(bytes.NewReader(rawHeader), br),
+			kmsProvider, aad)
+	}
+	dek, err := decryptDek(h, kmsProvider)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(br, h, additionalData(rawHeader, aad), dek)
+}
+
+//decryptAll reads the whole of a ciphertext that wasn't streamed, and returns
+//a Reader of its plaintext
+func decryptAll(r io.Reader, kmsProvider KmsProvider,
+	aad AAD) (io.Reader, error) {
+	cipherBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := PlainTextFromPrimitives(cipherBytes, kmsProvider, aad)
+	return bytes.NewReader(plaintext), err
+}
+
+//newDecryptReader returns a reader decrypting chunks with the DEK,
+//authenticating the additional data alongside each chunk
+func newDecryptReader(r *bufio.Reader, h header, additionalData,
+	dek []byte) (*decryptReader, error) {
+	if len(h.nonce) != streamNoncePrefixLength || h.chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("%w: invalid nonce prefix or chunk size",
+			ErrInvalidCiphertext)
+	}
+	aesgcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:              r,
+		aesgcm:         aesgcm,
+		noncePrefix:    h.nonce,
+		additionalData: additionalData,
+		chunk:          make([]byte, int(h.chunkSize)+gcmTagLength),
+	}, nil
+}
+
+//decryptReader decrypts a chunk at a time, returning its plaintext before
+//reading the next one
+type decryptReader struct {
+	r              *bufio.Reader
+	aesgcm         cipher.AEAD
+	noncePrefix    []byte
+	additionalData []byte
+	counter        uint32
+	chunk          []byte
+	plaintext      []byte
+	done           bool
+}
+
+//Read reads decrypted plaintext into p
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plaintext) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plaintext)
+	d.plaintext = d.plaintext[n:]
+	return n, nil
+}
+
+//openChunk reads and decrypts the next chunk, which is the final chunk if
+//it's shorter than a full chunk or nothing follows it
+func (d *decryptReader) openChunk() (err error) {
+	n, err := io.ReadFull(d.r, d.chunk)
+	final := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err == nil {
+		_, err = d.r.Peek(1)
+		final = err == io.EOF
+	}
+	if err != nil && !final {
+		return
+	}
+	nonce := chunkNonce(d.noncePrefix, d.counter, final)
+	if d.plaintext, err = d.aesgcm.Open(d.chunk[:0], nonce, d.chunk[:n],
+		d.additionalData); err != nil {
+		return ErrAuthenticationFailed
+	}
+	d.counter++
+	d.done = final
+	return nil
+}
+
+//chunkNonce returns the nonce for a chunk
+func chunkNonce(noncePrefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, nonceLength)
+	copy(nonce, noncePrefix)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixLength:], counter)
+	if final {
+		nonce[nonceLength-1] = 1
+	}
+	return nonce
+}
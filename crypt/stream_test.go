@@ -0,0 +1,115 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//encryptStream encrypts the plaintext using the stream writer
+func encryptStream(t *testing.T, plaintext []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, stubKms{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//decryptStream decrypts the ciphertext using the stream reader
+func decryptStream(cipherBytes []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(cipherBytes), stubKms{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+var streamLengths = []int{0, 1, defaultChunkSize - 1, defaultChunkSize,
+	defaultChunkSize + 1, 3 * defaultChunkSize}
+
+func TestStreamRoundTrip(t *testing.T) {
+	for _, length := range streamLengths {
+		plaintext, _ := randByteSlice(length)
+		result, err := decryptStream(encryptStream(t, plaintext))
+		if err != nil || !bytes.Equal(result, plaintext) {
+			t.Errorf("Round trip of %d bytes failed (%v)", length, err)
+		}
+		if result, err = PlainTextFromPrimitives(encryptStream(t, plaintext),
+			stubKms{}, nil); err != nil || !bytes.Equal(result, plaintext) {
+			t.Errorf("Decrypting %d streamed bytes in one go failed (%v)", length, err)
+		}
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	plaintext, _ := randByteSlice(2 * defaultChunkSize)
+	cipherBytes := encryptStream(t, plaintext)
+	// remove the final chunk, leaving the first (full) chunk intact
+	truncated := cipherBytes[:len(cipherBytes)-(defaultChunkSize+gcmTagLength)]
+	if _, err := decryptStream(truncated); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Got %v, want %v", err, ErrAuthenticationFailed)
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	plaintext, _ := randByteSlice(2 * defaultChunkSize)
+	cipherBytes := encryptStream(t, plaintext)
+	cipherBytes[len(cipherBytes)-1] ^= 1
+	if _, err := decryptStream(cipherBytes); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Got %v, want %v", err, ErrAuthenticationFailed)
+	}
+}
+
+func TestDecryptReaderNotStreamed(t *testing.T) {
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, stubKms{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := decryptStream(sealed)
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Errorf("Got %s (%v), want %s", result, err, plaintext)
+	}
+}
+
+func TestEncryptDecryptStream(t *testing.T) {
+	plaintext, _ := randByteSlice(2*defaultChunkSize + 5)
+	for _, singleLine := range []bool{true, false} {
+		var cipherBuf, plainBuf bytes.Buffer
+		err := EncryptStream(&cipherBuf, bytes.NewReader(plaintext), singleLine,
+			stubKms{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(cipherBuf.String(), "\n") == singleLine {
+			t.Errorf("Unexpected newlines with singleLine %v", singleLine)
+		}
+		err = DecryptStream(&plainBuf, &cipherBuf, stubKms{}, nil)
+		if err != nil || !bytes.Equal(plainBuf.Bytes(), plaintext) {
+			t.Errorf("Round trip failed (%v)", err)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//Structured files (see documentFormats) have each leaf value encrypted on its
+//own, so their keys and structure stay readable, e.g. in code review. Values
+//are encrypted as:
+//
+//	mantle:v1:tag:base64(nonce[12]sealedValue)
+//
+//where tag is the value's YAML tag (str, int, bool...), restored when
+//decrypting. Every value is sealed with the file's DEK, using a new nonce,
+//and authenticated alongside the header, the AAD and the value's path, so it
+//can't be moved to another key. The header, along with a MAC over every key
+//and value in order, so they can't be reordered, removed or edited, is
+//recorded under the top-level mantle key.
+const (
+	encryptedValuePrefix = "mantle:v1:"
+	metadataKey          = "mantle"
+	metadataHeader       = "header"
+	metadataKeyRegex     = "keyRegex"
+	metadataMac          = "mac"
+	nullTag              = "!!null"
+)
+
+//structuredMacKeyInfo derives the MAC key from the DEK, so the DEK isn't used
+//for both GCM and HMAC
+var structuredMacKeyInfo = []byte("mantle structured MAC")
+
+//structuredDocument encrypts or decrypts the values of a document
+type structuredDocument struct {
+	dek       []byte
+	rawHeader []byte
+	aad       AAD
+	keyRegex  string
+}
+
+//EncryptStructured encrypts the leaf values of a dotenv, JSON or YAML
+//document (see documentFormats), using a new DEK encrypted by the
+//KmsProvider, leaving its keys and structure readable. If the keyRegex isn't
+//empty, only values under a key matching it are encrypted. The AAD (which may
+//be nil) must be supplied again to decrypt it.
+func EncryptStructured(plaintext []byte, format, keyRegex string,
+	kmsProvider KmsProvider, aad AAD) ([]byte, error) {
+	doc, marshal, err := parseDocument(plaintext, format)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	if metadataNode(root) != nil {
+		return nil, fmt.Errorf("%s document already has a top-level %s key, "+
+			"it's either encrypted already or needs renaming", format, metadataKey)
+	}
+	walker, err := newDocumentWalker(keyRegex)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newStructuredDocument(kmsProvider, aad, keyRegex)
+	if err != nil {
+		return nil, err
+	}
+	walker.fn = d.encryptLeaf
+	if err = walker.walk(root, nil, walker.keyRegex == nil); err != nil {
+		return nil, err
+	}
+	d.appendMetadata(root)
+	return marshal()
+}
+
+//newStructuredDocument uses the KmsProvider to encrypt a new DEK, recording
+//it in a header
+func newStructuredDocument(kmsProvider KmsProvider, aad AAD,
+	keyRegex string) (*structuredDocument, error) {
+	dek, err := randByteSlice(dekLength)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newHeader(kmsProvider, dek, nil, aad)
+	if err != nil {
+		return nil, err
+	}
+	rawHeader, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &structuredDocument{dek: dek, rawHeader: rawHeader, aad: aad,
+		keyRegex: keyRegex}, nil
+}
+
+//DecryptStructured decrypts the values of a document encrypted by
+//EncryptStructured, using the KmsProvider to decrypt the DEK, once the MAC
+//has been checked. The AAD must match the AAD it was encrypted with.
+func DecryptStructured(data []byte, format string, kmsProvider KmsProvider,
+	aad AAD) ([]byte, error) {
+	doc, marshal, err := parseDocument(data, format)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	d, mac, err := readMetadata(root, kmsProvider, aad)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, d.mac(root)) {
+		return nil, fmt.Errorf("%w: MAC doesn't match the document, it's been "+
+			"edited or the AAD is different", ErrAuthenticationFailed)
+	}
+	removeMetadata(root)
+	walker := documentWalker{fn: d.decryptLeaf}
+	if err = walker.walk(root, nil, true); err != nil {
+		return nil, err
+	}
+	return marshal()
+}
+
+//structuredHeader returns the header recorded in an encrypted document
+func structuredHeader(data []byte, format string) (header, error) {
+	doc, _, err := parseDocument(data, format)
+	if err != nil {
+		return header{}, err
+	}
+	rawHeader, err := base64.StdEncoding.DecodeString(metadataValue(
+		metadataNode(doc.Content[0]), metadataHeader))
+	if err != nil || len(rawHeader) == 0 {
+		return header{}, fmt.Errorf("%w: malformed %s metadata",
+			ErrInvalidCiphertext, metadataKey)
+	}
+	h, _, _, err := parseHeader(rawHeader)
+	return h, err
+}
+
+//structuredKeyRegex returns the key regex recorded in an encrypted document,
+//so it can be encrypted again the same way
+func structuredKeyRegex(data []byte, format string) (string, error) {
+	doc, _, err := parseDocument(data, format)
+	if err != nil {
+		return "", err
+	}
+	return metadataValue(metadataNode(doc.Content[0]), metadataKeyRegex), nil
+}
+
+//encryptLeaf encrypts a scalar value under a key matching the key regex,
+//other than null
+func (d *structuredDocument) encryptLeaf(leaf *yaml.Node, path []string,
+	matched bool) error {
+	if !matched || leaf.Kind != yaml.ScalarNode || leaf.ShortTag() == nullTag {
+		return nil
+	}
+	nonce, err := randByteSlice(nonceLength)
+	if err != nil {
+		return err
+	}
+	sealed, err := cipherText([]byte(leaf.Value), d.dek, nonce,
+		d.valueAdditionalData(path), true)
+	if err != nil {
+		return err
+	}
+	leaf.Value = encryptedValuePrefix + strings.TrimPrefix(leaf.ShortTag(), "!!") +
+		":" + base64.StdEncoding.EncodeToString(append(nonce, sealed...))
+	leaf.Tag, leaf.Style = "", 0
+	return nil
+}
+
+//decryptLeaf decrypts an encrypted value, restoring its tag
+func (d *structuredDocument) decryptLeaf(leaf *yaml.Node, path []string,
+	_ bool) error {
+	if leaf.Kind != yaml.ScalarNode ||
+		!strings.HasPrefix(leaf.Value, encryptedValuePrefix) {
+		return nil
+	}
+	tag, sealed, err := decodeEncryptedValue(leaf.Value)
+	if err != nil {
+		return fmt.Errorf("%w: value of %s", err, strings.Join(path, "."))
+	}
+	plaintext, err := cipherText(sealed[nonceLength:], d.dek,
+		sealed[:nonceLength], d.valueAdditionalData(path), false)
+	if err != nil {
+		return fmt.Errorf("%w: value of %s", err, strings.Join(path, "."))
+	}
+	leaf.Value, leaf.Tag, leaf.Style = string(plaintext), "!!"+tag, 0
+	if strings.Contains(leaf.Value, "\n") {
+		leaf.Style = yaml.LiteralStyle
+	}
+	return nil
+}
+
+//decodeEncryptedValue splits an encrypted value into its tag, and the nonce
+//followed by the sealed value
+func decodeEncryptedValue(value string) (tag string, sealed []byte, err error) {
+	value = strings.TrimPrefix(value, encryptedValuePrefix)
+	i := strings.LastIndex(value, ":")
+	if i < 0 {
+		return "", nil, fmt.Errorf("%w: encrypted value has no tag",
+			ErrInvalidCiphertext)
+	}
+	sealed, err = base64.StdEncoding.DecodeString(value[i+1:])
+	if err != nil || len(sealed) < nonceLength {
+		return "", nil, fmt.Errorf("%w: malformed encrypted value",
+			ErrInvalidCiphertext)
+	}
+	return value[:i], sealed, nil
+}
+
+//valueAdditionalData returns the data authenticated alongside a value, i.e.
+//the raw header, the AAD and the value's path
+func (d *structuredDocument) valueAdditionalData(path []string) []byte {
+	return appendLengthPrefixed(additionalData(d.rawHeader, d.aad),
+		string(encodePath(path)))
+}
+
+//encodePath returns the canonical encoding of a path, each key (or sequence
+//index) prefixed by its length
+func encodePath(path []string) (encoded []byte) {
+	for _, key := range path {
+		encoded = appendLengthPrefixed(encoded, key)
+	}
+	return
+}
+
+//mac returns the HMAC-SHA256, keyed by a key derived from the DEK, of the
+//header, AAD and key regex, followed by the path, tag and value of every leaf
+//in order, as they're stored, so it can be checked before decrypting
+func (d *structuredDocument) mac(root *yaml.Node) []byte {
+	keyMac := hmac.New(sha256.New, d.dek)
+	keyMac.Write(structuredMacKeyInfo)
+	mac := hmac.New(sha256.New, keyMac.Sum(nil))
+	mac.Write(appendLengthPrefixed(additionalData(d.rawHeader, d.aad),
+		d.keyRegex))
+	walker := documentWalker{fn: func(leaf *yaml.Node, path []string, _ bool) error {
+		mac.Write(appendLengthPrefixed(nil, string(encodePath(path))))
+		mac.Write(appendLengthPrefixed(nil, leaf.ShortTag()))
+		mac.Write(appendLengthPrefixed(nil, leaf.Value))
+		return nil
+	}}
+	walker.walk(root, nil, true)
+	return mac.Sum(nil)
+}
+
+//appendMetadata records the header, key regex and MAC under the metadata key
+func (d *structuredDocument) appendMetadata(root *yaml.Node) {
+	metadata := &yaml.Node{Kind: yaml.MappingNode}
+	addMetadataValue(metadata, metadataHeader,
+		base64.StdEncoding.EncodeToString(d.rawHeader))
+	if d.keyRegex != "" {
+		addMetadataValue(metadata, metadataKeyRegex, d.keyRegex)
+	}
+	addMetadataValue(metadata, metadataMac,
+		base64.StdEncoding.EncodeToString(d.mac(root)))
+	root.Content = append(root.Content, stringNode(metadataKey), metadata)
+}
+
+func addMetadataValue(metadata *yaml.Node, key, value string) {
+	metadata.Content = append(metadata.Content, stringNode(key),
+		stringNode(value))
+}
+
+func stringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+//readMetadata reads the metadata recorded by appendMetadata, using the
+//KmsProvider to decrypt the DEK, and returns the MAC
+func readMetadata(root *yaml.Node, kmsProvider KmsProvider,
+	aad AAD) (d *structuredDocument, mac []byte, err error) {
+	metadata := metadataNode(root)
+	if metadata == nil {
+		return nil, nil, fmt.Errorf("%w: document has no top-level %s key, "+
+			"it isn't encrypted", ErrInvalidCiphertext, metadataKey)
+	}
+	d = &structuredDocument{aad: aad,
+		keyRegex: metadataValue(metadata, metadataKeyRegex)}
+	d.rawHeader, err = base64.StdEncoding.DecodeString(metadataValue(metadata,
+		metadataHeader))
+	mac, macErr := base64.StdEncoding.DecodeString(metadataValue(metadata,
+		metadataMac))
+	if err != nil || macErr != nil {
+		return nil, nil, fmt.Errorf("%w: malformed %s metadata",
+			ErrInvalidCiphertext, metadataKey)
+	}
+	h, _, _, err := parseHeader(d.rawHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.dek, err = decryptDek(h, kmsProvider)
+	return d, mac, err
+}
+
+//metadataNode returns the mapping under the top-level metadata key, or nil
+func metadataNode(root *yaml.Node) *yaml.Node {
+	return mappingValue(root, metadataKey)
+}
+
+//metadataValue returns the metadata's value for the key, or "" if it isn't
+//set
+func metadataValue(metadata *yaml.Node, key string) string {
+	if value := mappingValue(metadata, key); value != nil {
+		return value.Value
+	}
+	return ""
+}
+
+//mappingValue returns the mapping's value for the key, or nil if it isn't
+//set (or it isn't a mapping)
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+//removeMetadata removes the top-level metadata key
+func removeMetadata(root *yaml.Node) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == metadataKey {
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+//leafFunc is called with each leaf of a document, its path, and whether a
+//key on its path matched the key regex
+type leafFunc func(leaf *yaml.Node, path []string, matched bool) error
+
+//documentWalker calls fn with every leaf of a document, other than the
+//metadata, in order
+type documentWalker struct {
+	keyRegex *regexp.Regexp
+	fn       leafFunc
+}
+
+func newDocumentWalker(keyRegex string) (w documentWalker, err error) {
+	if keyRegex != "" {
+		if w.keyRegex, err = regexp.Compile(keyRegex); err != nil {
+			err = fmt.Errorf("invalid key regex: %w", err)
+		}
+	}
+	return
+}
+
+func (w documentWalker) walk(node *yaml.Node, path []string,
+	matched bool) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return w.walkMapping(node, path, matched)
+	case yaml.SequenceNode:
+		return w.walkSequence(node, path, matched)
+	}
+	return w.fn(node, path, matched)
+}
+
+func (w documentWalker) walkMapping(node *yaml.Node, path []string,
+	matched bool) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if len(path) == 0 && key == metadataKey {
+			continue
+		}
+		if err := w.walk(node.Content[i+1], appendPath(path, key),
+			w.matches(matched, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w documentWalker) walkSequence(node *yaml.Node, path []string,
+	matched bool) error {
+	for i, child := range node.Content {
+		if err := w.walk(child, appendPath(path, strconv.Itoa(i)),
+			matched); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//matches reports whether values under the key match the key regex, i.e.
+//the key or one of its parents matches
+func (w documentWalker) matches(matched bool, key string) bool {
+	return matched || w.keyRegex != nil && w.keyRegex.MatchString(key)
+}
+
+//appendPath returns a new path, so sibling paths don't share a backing array
+func appendPath(path []string, key string) []string {
+	return append(path[:len(path):len(path)], key)
+}
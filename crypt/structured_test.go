@@ -0,0 +1,175 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var structuredYAML = []byte(`# service config
+db:
+  user: admin # the user
+  password: s3cr3t
+  port: 5432
+enabled: true
+tokens:
+  - abc
+  - "123"
+empty: null
+cert: |
+  line1
+  line2
+`)
+
+func TestStructuredRoundTrip(t *testing.T) {
+	encrypted, err := EncryptStructured(structuredYAML, "yaml", "",
+		newTestLocalKms(t), AAD{"env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"user: admin", "s3cr3t", "port: 5432", "line1"} {
+		if bytes.Contains(encrypted, []byte(secret)) {
+			t.Errorf("Encrypted document contains %s:\n%s", secret, encrypted)
+		}
+	}
+	if !bytes.Contains(encrypted, []byte("  password: mantle:v1:str:")) {
+		t.Errorf("Expected keys to be readable:\n%s", encrypted)
+	}
+	if _, err = DecryptStructured(encrypted, "yaml", newTestLocalKms(t),
+		AAD{"env": "prod"}); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Got %v decrypting with another key, want %v", err,
+			ErrAuthenticationFailed)
+	}
+}
+
+//encryptStructuredStub encrypts the document with the stubKms
+func encryptStructuredStub(t *testing.T, plaintext []byte, format,
+	keyRegex string) []byte {
+	encrypted, err := EncryptStructured(plaintext, format, keyRegex, stubKms{},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
+
+func TestDecryptStructured(t *testing.T) {
+	encrypted := encryptStructuredStub(t, structuredYAML, "yaml", "")
+	plaintext, err := DecryptStructured(encrypted, "yaml", stubKms{}, nil)
+	if err != nil || !bytes.Equal(plaintext, structuredYAML) {
+		t.Errorf("Got %s (%v), want %s", plaintext, err, structuredYAML)
+	}
+}
+
+func TestStructuredKeyRegex(t *testing.T) {
+	encrypted := encryptStructuredStub(t, structuredYAML, "yaml", "^(db|cert)$")
+	for _, want := range []string{"enabled: true", "  - abc", "  port: mantle:v1:int:"} {
+		if !bytes.Contains(encrypted, []byte(want)) {
+			t.Errorf("Expected %q in:\n%s", want, encrypted)
+		}
+	}
+	if keyRegex, err := structuredKeyRegex(encrypted, "yaml"); err != nil ||
+		keyRegex != "^(db|cert)$" {
+		t.Errorf("Got key regex %q (%v)", keyRegex, err)
+	}
+}
+
+var tamperedStructuredTests = map[string]func(string) string{
+	"edited plain value": func(s string) string {
+		return strings.Replace(s, "enabled: true", "enabled: false", 1)
+	},
+	"reordered values": func(s string) string {
+		lines := strings.Split(s, "\n")
+		lines[7], lines[8] = lines[8], lines[7]
+		return strings.Join(lines, "\n")
+	},
+	"removed value": func(s string) string {
+		return strings.Replace(s, "empty: null\n", "", 1)
+	},
+}
+
+func TestStructuredTampered(t *testing.T) {
+	encrypted := string(encryptStructuredStub(t, structuredYAML, "yaml", "^db$"))
+	for name, tamper := range tamperedStructuredTests {
+		_, err := DecryptStructured([]byte(tamper(encrypted)), "yaml", stubKms{},
+			nil)
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrAuthenticationFailed)
+		}
+	}
+}
+
+func TestStructuredWrongAAD(t *testing.T) {
+	encrypted, err := EncryptStructured(structuredYAML, "yaml", "", stubKms{},
+		AAD{"env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecryptStructured(encrypted, "yaml", stubKms{}, AAD{"env": "dev"})
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Got %v, want %v", err, ErrAuthenticationFailed)
+	}
+}
+
+func TestStructuredMetadataKey(t *testing.T) {
+	encrypted := encryptStructuredStub(t, structuredYAML, "yaml", "")
+	if _, err := EncryptStructured(encrypted, "yaml", "", stubKms{},
+		nil); err == nil {
+		t.Error("Expected error encrypting an encrypted document")
+	}
+	if _, err := DecryptStructured(structuredYAML, "yaml", stubKms{},
+		nil); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Got %v decrypting a plaintext document, want %v", err,
+			ErrInvalidCiphertext)
+	}
+}
+
+func TestStructuredJSON(t *testing.T) {
+	plaintext := []byte(`{"db": {"password": "p\u00e9\/x", "port": 5432}, "html": "<&>"}`)
+	want := "{\n  \"db\": {\n    \"password\": \"pé/x\",\n    \"port\": 5432\n  },\n" +
+		"  \"html\": \"<&>\"\n}\n"
+	encrypted := encryptStructuredStub(t, plaintext, "json", "password")
+	if !bytes.Contains(encrypted, []byte(`"password": "mantle:v1:str:`)) {
+		t.Errorf("Expected the password to be encrypted:\n%s", encrypted)
+	}
+	decrypted, err := DecryptStructured(encrypted, "json", stubKms{}, nil)
+	if err != nil || string(decrypted) != want {
+		t.Errorf("Got %s (%v), want %s", decrypted, err, want)
+	}
+}
+
+func TestStructuredDotenv(t *testing.T) {
+	plaintext := []byte("# database\nDB_USER=admin\nDB_PASSWORD='s3cr3t' # monthly\n")
+	encrypted := encryptStructuredStub(t, plaintext, "dotenv", "PASSWORD")
+	for _, want := range []string{"# database\nDB_USER=admin\n",
+		"DB_PASSWORD='mantle:v1:str:", "' # monthly\n", "\nmantle_header=",
+		"\nmantle_keyRegex=PASSWORD\n", "\nmantle_mac="} {
+		if !bytes.Contains(encrypted, []byte(want)) {
+			t.Errorf("Expected %q in:\n%s", want, encrypted)
+		}
+	}
+	decrypted, err := DecryptStructured(encrypted, "dotenv", stubKms{}, nil)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Got %s (%v), want %s", decrypted, err, plaintext)
+	}
+	tampered := bytes.Replace(encrypted, []byte("admin"), []byte("root"), 1)
+	if _, err = DecryptStructured(tampered, "dotenv", stubKms{},
+		nil); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Got %v, want %v", err, ErrAuthenticationFailed)
+	}
+}
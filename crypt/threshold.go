@@ -0,0 +1,165 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"context"
+	"fmt"
+)
+
+//thresholdProviderName is the provider ID recorded in the header of a
+//ciphertext whose DEK is split into shares, each wrapped by a different KMS
+//key
+const thresholdProviderName = "THRESHOLD"
+
+//ThresholdKms splits the DEK into a share for each of its providers' keys,
+//using Shamir's secret sharing, so decrypting it needs any Threshold of them,
+//e.g. 2 of AWS, GCP and Vault for break-glass secrets. The encrypted DEK is
+//threshold[1] followed by every wrapped share (see wrappedDek), in order of
+//their x values from 1.
+type ThresholdKms struct {
+	Threshold int
+	Providers []KmsProvider
+}
+
+//NewThresholdKms creates a KmsProvider for each key, as NewMultiKms does,
+//needing threshold of them to decrypt. A threshold of 1 returns a MultiKms.
+//The keys must be different, or one key could unwrap several shares.
+func NewThresholdKms(keys []KeyRecipient, threshold int,
+	opts Defaults) (KmsProvider, error) {
+	if err := checkThreshold(keys, threshold); err != nil {
+		return nil, err
+	}
+	kmsProvider, err := NewMultiKms(keys, opts)
+	if multi, ok := kmsProvider.(MultiKms); ok && threshold > 1 {
+		return ThresholdKms{Threshold: threshold, Providers: multi.Providers}, err
+	}
+	return kmsProvider, err
+}
+
+//checkThreshold checks the threshold is achievable, and no key is given more
+//than once
+func checkThreshold(keys []KeyRecipient, threshold int) error {
+	if threshold < 1 || threshold > len(keys) || len(keys) > 255 {
+		return fmt.Errorf("threshold must be between 1 and the number of keys "+
+			"(%d), which can't exceed 255", len(keys))
+	}
+	seen := map[KeyRecipient]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			return fmt.Errorf("key %s:%s given more than once", key.Provider,
+				key.KeyName)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+//Name returns "THRESHOLD"
+func (t ThresholdKms) Name() string {
+	return thresholdProviderName
+}
+
+//Encrypt splits the DEK into shares, wrapping each with a different provider,
+//failing if any of them fails
+func (t ThresholdKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte,
+	err error) {
+	shares, err := splitSecret(dek, len(t.Providers), t.Threshold)
+	if err != nil {
+		return nil, kmsError(t.Name(), true, err)
+	}
+	encryptedDek = []byte{byte(t.Threshold)}
+	for i, kmsProvider := range t.Providers {
+		w, err := wrapDek(ctx, kmsProvider, shares[i])
+		if err != nil {
+			return nil, err
+		}
+		encryptedDek = w.appendTo(encryptedDek)
+	}
+	return
+}
+
+//canDecrypt reports whether at least Threshold of the providers can decrypt
+func (t ThresholdKms) canDecrypt() bool {
+	decrypting := 0
+	for _, kmsProvider := range t.Providers {
+		if canDecrypt(kmsProvider) {
+			decrypting++
+		}
+	}
+	return decrypting >= t.Threshold
+}
+
+func (t ThresholdKms) providers() []KmsProvider {
+	return t.Providers
+}
+
+//Decrypt unwraps shares with every provider they could have been wrapped by,
+//until there are enough to recover the DEK
+func (t ThresholdKms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte,
+	err error) {
+	threshold, wrapped, err := decodeThreshold(encryptedDek)
+	if err != nil {
+		return
+	}
+	return unwrapShares(ctx, t.Providers, threshold, wrapped)
+}
+
+//unwrapShares recovers a DEK split by ThresholdKms, using the KmsProviders to
+//unwrap threshold of its shares
+func unwrapShares(ctx context.Context, kmsProviders []KmsProvider,
+	threshold int, wrapped []wrappedDek) ([]byte, error) {
+	var xs []byte
+	var shares [][]byte
+	var errs unwrapErrors
+	for i := 0; i < len(wrapped) && len(shares) < threshold; i++ {
+		if share, ok := wrapped[i].unwrapWithAny(ctx, kmsProviders, &errs); ok {
+			xs, shares = append(xs, byte(i+1)), append(shares, share)
+		}
+	}
+	if len(shares) == threshold {
+		return combineShares(xs, shares), nil
+	}
+	return nil, thresholdError(threshold, len(shares), wrapped, kmsProviders, errs)
+}
+
+//decodeThreshold decodes the threshold and wrapped shares encoded by
+//ThresholdKms
+func decodeThreshold(encryptedDek []byte) (int, []wrappedDek, error) {
+	if len(encryptedDek) == 0 {
+		return 0, nil, fmt.Errorf("%w: missing threshold", ErrInvalidCiphertext)
+	}
+	threshold := int(encryptedDek[0])
+	wrapped, err := decodeWrappedDeks(encryptedDek[1:])
+	if err == nil && (threshold < 2 || threshold > len(wrapped)) {
+		err = fmt.Errorf("%w: threshold of %d for %d shares", ErrInvalidCiphertext,
+			threshold, len(wrapped))
+	}
+	return threshold, wrapped, err
+}
+
+//thresholdError explains why too few shares were unwrapped, i.e. KMS requests
+//failed, or too few of the keys were given
+func thresholdError(threshold, unwrapped int, wrapped []wrappedDek,
+	kmsProviders []KmsProvider, errs unwrapErrors) error {
+	if len(errs) > 0 {
+		return kmsError(thresholdProviderName, false, fmt.Errorf(
+			"%d of the %d shares needed were unwrapped: %w", unwrapped, threshold,
+			errs))
+	}
+	return fmt.Errorf("%w: CipherText needs %d of its keys from KMS Providers %s, "+
+		"but KMS Provider %s could only unwrap %d", ErrProviderMismatch, threshold,
+		wrappedProviderIDs(wrapped), providerNames(kmsProviders), unwrapped)
+}
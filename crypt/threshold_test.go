@@ -0,0 +1,112 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"filippo.io/age"
+)
+
+//sealWithThreshold seals the plaintext with a new DEK split between the keys
+func sealWithThreshold(t *testing.T, plaintext []byte, threshold int,
+	keys ...KmsProvider) []byte {
+	sealed, err := sealWithNewDek(plaintext, ThresholdKms{Threshold: threshold,
+		Providers: keys}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestThresholdKms(t *testing.T) {
+	aws, gcp, vault := newTestLocalKms(t), newTestLocalKms(t), newTestLocalKms(t)
+	plaintext := []byte("helloworld")
+	sealed := sealWithThreshold(t, plaintext, 2, aws, gcp, vault)
+	quorums := map[string]KmsProvider{
+		"first and second": MultiKms{Providers: []KmsProvider{aws, gcp}},
+		"second and third": MultiKms{Providers: []KmsProvider{vault, gcp}},
+		"every key": ThresholdKms{Threshold: 2,
+			Providers: []KmsProvider{aws, gcp, vault}},
+		"wrong key first": MultiKms{Providers: []KmsProvider{newTestLocalKms(t),
+			vault, aws}},
+	}
+	for name, decrypter := range quorums {
+		result, err := PlainTextFromPrimitives(sealed, decrypter, nil)
+		if err != nil || !bytes.Equal(result, plaintext) {
+			t.Errorf("Decryption with %s keys failed (%v)", name, err)
+		}
+	}
+}
+
+func TestThresholdKmsTooFewKeys(t *testing.T) {
+	local, identity := newTestLocalKms(t), newTestAgeIdentity(t)
+	sealed := sealWithThreshold(t, []byte("helloworld"), 2, local,
+		AgeKms{Recipients: []age.Recipient{identity.Recipient()}},
+		newTestLocalKms(t))
+	if _, err := PlainTextFromPrimitives(sealed,
+		AgeKms{Identities: []age.Identity{identity}}, nil); !errors.Is(err,
+		ErrProviderMismatch) {
+		t.Errorf("Got %v, want %v for one key", err, ErrProviderMismatch)
+	}
+	_, err := PlainTextFromPrimitives(sealed, local, nil)
+	if !errors.Is(err, ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v for one right key", err, ErrKMSUnavailable)
+	}
+}
+
+func TestNewThresholdKms(t *testing.T) {
+	keys := []KeyRecipient{{"AWS", "awsKey"}, {"GCP", "gcpKey"},
+		{"VAULT", "vaultKey"}}
+	kmsProvider, err := NewThresholdKms(keys, 2, Defaults{})
+	if threshold, ok := kmsProvider.(ThresholdKms); err != nil || !ok ||
+		threshold.Threshold != 2 || len(threshold.Providers) != 3 {
+		t.Errorf("Expected a ThresholdKms of 2 of 3 keys (%v)", err)
+	}
+	if kmsProvider, _ = NewThresholdKms(keys, 1, Defaults{}); kmsProvider.Name() !=
+		multiProviderName {
+		t.Errorf("Got %s, want a MultiKms for a threshold of 1", kmsProvider.Name())
+	}
+}
+
+func TestNewThresholdKmsInvalid(t *testing.T) {
+	keys := []KeyRecipient{{"AWS", "awsKey"}, {"GCP", "gcpKey"},
+		{"VAULT", "vaultKey"}}
+	for _, threshold := range []int{0, 4} {
+		if _, err := NewThresholdKms(keys, threshold, Defaults{}); err == nil {
+			t.Errorf("Expected error for a threshold of %d", threshold)
+		}
+	}
+	if _, err := NewThresholdKms(append(keys, keys[0]), 2, Defaults{}); err == nil {
+		t.Error("Expected error for a key given twice")
+	}
+}
+
+func TestDecodeThresholdInvalid(t *testing.T) {
+	share := wrappedDek{providerID: "STUB", encryptedDek: []byte("share")}.appendTo(nil)
+	for _, encryptedDek := range [][]byte{
+		nil,
+		append([]byte{1}, share...),
+		append([]byte{2}, share...),
+		append([]byte{2}, append(share, share[:len(share)-1]...)...),
+	} {
+		if _, _, err := decodeThreshold(encryptedDek); !errors.Is(err,
+			ErrInvalidCiphertext) {
+			t.Errorf("Got %v, want %v", err, ErrInvalidCiphertext)
+		}
+	}
+}
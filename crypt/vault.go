@@ -0,0 +1,250 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	mustRegisterProvider("VAULT", newVaultKms)
+}
+
+const (
+	defaultVaultAddress      = "https://127.0.0.1:8200"
+	defaultVaultTransitMount = "transit"
+)
+
+//vaultKubernetesTokenPath is where Kubernetes mounts the pod's service
+//account token
+var vaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+//VaultKms uses the HashiCorp Vault Transit secrets engine to encrypt DEKs.
+//The encrypted DEK is Vault's ciphertext, e.g. vault:v1:..., which records the
+//version of the key used, so ciphertexts can be rewrapped with the latest
+//version using reencrypt after the key is rotated.
+type VaultKms struct {
+	//KeyName is the name of the Transit key
+	KeyName string
+	//Address of the Vault server, e.g. https://vault:8200
+	Address string
+	//Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+	//TransitMount is the path the Transit secrets engine is mounted at
+	TransitMount string
+	//Token authenticates requests. If it's empty, a token is obtained by
+	//logging in with AppRole if RoleID is set, or Kubernetes auth if
+	//KubernetesRole is set.
+	Token string
+	//RoleID and SecretID are the AppRole credentials
+	RoleID   string
+	SecretID string
+	//KubernetesRole is the Vault role to log in as, using the pod's service
+	//account token
+	KubernetesRole string
+}
+
+//newVaultKms creates a VaultKms from the input flags, and the VAULT_ADDR,
+//VAULT_TOKEN (or ~/.vault-token) and VAULT_SECRET_ID environment variables
+func newVaultKms(opts Defaults) (KmsProvider, error) {
+	v := VaultKms{
+		KeyName:        opts.KeyName,
+		Address:        os.Getenv("VAULT_ADDR"),
+		Namespace:      opts.VaultNamespace,
+		TransitMount:   opts.VaultTransitMount,
+		Token:          os.Getenv("VAULT_TOKEN"),
+		RoleID:         opts.VaultRoleID,
+		SecretID:       os.Getenv("VAULT_SECRET_ID"),
+		KubernetesRole: opts.VaultKubernetesRole,
+	}
+	if v.Token == "" && v.RoleID == "" && v.KubernetesRole == "" {
+		v.Token = vaultTokenFile()
+	}
+	if v.KeyName == "" {
+		return nil, errors.New("the Vault Transit key name is required (-n,--keyName)")
+	}
+	return v, checkVaultKeyName(v.KeyName)
+}
+
+//vaultTokenFile returns the token stored by the vault cli, if there is one
+func vaultTokenFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	token, _ := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+	return strings.TrimSpace(string(token))
+}
+
+func (v VaultKms) keyID() string {
+	return v.KeyName
+}
+
+//checkKeyID checks the KeyName only names a key in the Transit mount
+func (v VaultKms) checkKeyID() error {
+	return checkVaultKeyName(v.KeyName)
+}
+
+//checkVaultKeyName checks the key name can't change the path of Transit
+//requests, to outside the Transit mount or to another operation
+func checkVaultKeyName(name string) error {
+	if strings.Contains(name, "/") || strings.Contains(name, "..") {
+		return fmt.Errorf("vault key name %q can't contain / or ..", name)
+	}
+	return nil
+}
+
+//Name returns "VAULT"
+func (v VaultKms) Name() string {
+	return "VAULT"
+}
+
+//Encrypt uses the Transit key to encrypt the DEK
+func (v VaultKms) Encrypt(ctx context.Context, dek []byte) (encryptedDek []byte, err error) {
+	var resp struct {
+		Data struct{ Ciphertext string }
+	}
+	err = v.transit(ctx, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dek)}, &resp)
+	if err == nil {
+		encryptedDek = []byte(resp.Data.Ciphertext)
+	}
+	return encryptedDek, kmsError(v.Name(), true, err)
+}
+
+//Decrypt uses the Transit key to decrypt the DEK
+func (v VaultKms) Decrypt(ctx context.Context, encryptedDek []byte) (dek []byte, err error) {
+	var resp struct {
+		Data struct{ Plaintext string }
+	}
+	err = v.transit(ctx, "decrypt", map[string]string{
+		"ciphertext": string(encryptedDek)}, &resp)
+	if err == nil {
+		dek, err = base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	}
+	return dek, kmsError(v.Name(), false, err)
+}
+
+//transit sends a request to the Transit secrets engine, logging in first if
+//there's no token
+func (v VaultKms) transit(ctx context.Context, operation string,
+	req map[string]string, resp interface{}) (err error) {
+	if err = checkVaultKeyName(v.KeyName); err != nil {
+		return
+	}
+	token := v.Token
+	if token == "" {
+		if token, err = v.login(ctx); err != nil {
+			return
+		}
+	}
+	mount := v.TransitMount
+	if mount == "" {
+		mount = defaultVaultTransitMount
+	}
+	return v.request(ctx, token, fmt.Sprintf("%s/%s/%s", mount, operation,
+		url.PathEscape(v.KeyName)), req, resp)
+}
+
+//login logs in using AppRole or Kubernetes auth, returning the client token
+func (v VaultKms) login(ctx context.Context) (string, error) {
+	path, req, err := v.loginRequest()
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		}
+	}
+	if err = v.request(ctx, "", path, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+//loginRequest returns the auth method's login path and request
+func (v VaultKms) loginRequest() (string, map[string]string, error) {
+	if v.RoleID != "" {
+		return "auth/approle/login", map[string]string{"role_id": v.RoleID,
+			"secret_id": v.SecretID}, nil
+	}
+	if v.KubernetesRole != "" {
+		jwt, err := ioutil.ReadFile(vaultKubernetesTokenPath)
+		return "auth/kubernetes/login", map[string]string{"role": v.KubernetesRole,
+			"jwt": strings.TrimSpace(string(jwt))}, err
+	}
+	return "", nil, errors.New("no Vault token, AppRole or Kubernetes role given")
+}
+
+//request POSTs the JSON request to the Vault API path, decoding the JSON
+//response
+func (v VaultKms) request(ctx context.Context, token, path string,
+	req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	address := v.Address
+	if address == "" {
+		address = defaultVaultAddress
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(address, "/")+"/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	v.setHeaders(httpReq, token)
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return vaultError(httpResp)
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+func (v VaultKms) setHeaders(req *http.Request, token string) {
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+}
+
+//vaultError returns the errors in a failed Vault response
+func vaultError(resp *http.Response) error {
+	var body struct {
+		Errors []string
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return fmt.Errorf("vault responded %s: %s", resp.Status,
+		strings.Join(body.Errors, ", "))
+}
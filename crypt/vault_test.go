@@ -0,0 +1,211 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//fakeVault implements the Transit encrypt/decrypt and login endpoints,
+//"encrypting" by prefixing the plaintext with the key version
+type fakeVault struct {
+	version   int
+	namespace string
+	logins    map[string]map[string]string
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	f := &fakeVault{version: 1, logins: map[string]map[string]string{
+		"/v1/auth/approle/login":    {"role_id": "role", "secret_id": "secret"},
+		"/v1/auth/kubernetes/login": {"role": "mantle", "jwt": "jwt"},
+	}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req map[string]string
+	json.NewDecoder(r.Body).Decode(&req)
+	f.namespace = r.Header.Get("X-Vault-Namespace")
+	if login, ok := f.logins[r.URL.Path]; ok {
+		f.login(w, login, req)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != "token" {
+		vaultRespond(w, http.StatusForbidden, nil)
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/transit/encrypt/mantle":
+		vaultRespond(w, http.StatusOK, map[string]interface{}{"data": map[string]string{
+			"ciphertext": fmt.Sprintf("vault:v%d:%s", f.version, req["plaintext"])}})
+	case "/v1/transit/decrypt/mantle":
+		f.decrypt(w, req["ciphertext"])
+	default:
+		vaultRespond(w, http.StatusNotFound, nil)
+	}
+}
+
+func (f *fakeVault) login(w http.ResponseWriter, login, req map[string]string) {
+	for key, value := range login {
+		if req[key] != value {
+			vaultRespond(w, http.StatusBadRequest, nil)
+			return
+		}
+	}
+	vaultRespond(w, http.StatusOK, map[string]interface{}{"auth": map[string]string{
+		"client_token": "token"}})
+}
+
+func (f *fakeVault) decrypt(w http.ResponseWriter, ciphertext string) {
+	var version int
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) == 3 {
+		fmt.Sscanf(parts[1], "v%d", &version)
+	}
+	if version < 1 || version > f.version {
+		vaultRespond(w, http.StatusBadRequest, nil)
+		return
+	}
+	vaultRespond(w, http.StatusOK, map[string]interface{}{"data": map[string]string{
+		"plaintext": parts[2]}})
+}
+
+func vaultRespond(w http.ResponseWriter, status int, body interface{}) {
+	if status != http.StatusOK {
+		body = map[string][]string{"errors": {http.StatusText(status)}}
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+//vaultEncryptedDek returns the encrypted DEK in the ciphertext's header
+func vaultEncryptedDek(t *testing.T, sealed []byte) string {
+	h, _, _, err := parseHeader(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h.encryptedDek)
+}
+
+func TestVaultRotation(t *testing.T) {
+	fake, server := newFakeVault(t)
+	v := VaultKms{KeyName: "mantle", Address: server.URL, Token: "token",
+		Namespace: "team"}
+	plaintext := []byte("helloworld")
+	sealed, err := sealWithNewDek(plaintext, v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dek := vaultEncryptedDek(t, sealed); !strings.HasPrefix(dek, "vault:v1:") {
+		t.Errorf("Got encrypted DEK %s, want vault:v1: prefix", dek)
+	}
+	fake.version = 2
+	if sealed = reencryptVault(t, sealed, plaintext, v); fake.namespace != "team" {
+		t.Errorf("Got namespace %q, want team", fake.namespace)
+	}
+	if dek := vaultEncryptedDek(t, sealed); !strings.HasPrefix(dek, "vault:v2:") {
+		t.Errorf("Got encrypted DEK %s, want vault:v2: prefix", dek)
+	}
+}
+
+//reencryptVault decrypts the ciphertext, and encrypts it with the latest
+//version of the key
+func reencryptVault(t *testing.T, sealed, plaintext []byte, v VaultKms) []byte {
+	result, err := PlainTextFromPrimitives(sealed, v, nil)
+	if err == nil {
+		sealed, err = sealWithNewDek(result, v, nil)
+	}
+	if err != nil || !bytes.Equal(result, plaintext) {
+		t.Fatalf("Got %s (%v), want %s", result, err, plaintext)
+	}
+	return sealed
+}
+
+func TestVaultLogin(t *testing.T) {
+	_, server := newFakeVault(t)
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(jwtPath, []byte("jwt\n"), 0600)
+	defer func(path string) { vaultKubernetesTokenPath = path }(vaultKubernetesTokenPath)
+	vaultKubernetesTokenPath = jwtPath
+	for _, v := range []VaultKms{
+		{RoleID: "role", SecretID: "secret"},
+		{KubernetesRole: "mantle"},
+	} {
+		v.KeyName, v.Address = "mantle", server.URL
+		if _, err := v.Encrypt(context.Background(), []byte("dek")); err != nil {
+			t.Errorf("Encrypting with %+v failed: %v", v, err)
+		}
+	}
+	v := VaultKms{KeyName: "mantle", Address: server.URL, RoleID: "role",
+		SecretID: "wrong"}
+	if _, err := v.Encrypt(context.Background(), []byte("dek")); !errors.Is(err,
+		ErrKMSUnavailable) {
+		t.Errorf("Got %v, want %v", err, ErrKMSUnavailable)
+	}
+}
+
+func TestNewVaultKms(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "https://vault:8200")
+	t.Setenv("VAULT_TOKEN", "token")
+	kmsProvider, err := newVaultKms(Defaults{KeyName: "mantle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := kmsProvider.(VaultKms); v.Address != "https://vault:8200" || v.Token != "token" {
+		t.Errorf("Got %+v, want address and token from the environment", v)
+	}
+	if _, err = newVaultKms(Defaults{}); err == nil {
+		t.Error("Expected error without a key name")
+	}
+}
+
+func TestNewVaultKmsKeyNameOutsideMount(t *testing.T) {
+	for _, keyName := range []string{"../../sys/mounts", "a/b", ".."} {
+		if _, err := newVaultKms(Defaults{KeyName: keyName}); err == nil {
+			t.Errorf("Expected error with key name %s", keyName)
+		}
+	}
+}
+
+func TestVaultKeyNameEscaped(t *testing.T) {
+	var path, query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		path, query = r.URL.Path, r.URL.RawQuery
+		vaultRespond(w, http.StatusNotFound, nil)
+	}))
+	t.Cleanup(server.Close)
+	v := VaultKms{KeyName: "my key?version=1#", Address: server.URL, Token: "token"}
+	v.Encrypt(context.Background(), []byte("dek"))
+	if path != "/v1/transit/encrypt/my key?version=1#" || query != "" {
+		t.Errorf("Got path %q and query %q, want the key name escaped", path, query)
+	}
+	v.KeyName = "../../sys/mounts"
+	if _, err := v.Encrypt(context.Background(), []byte("dek")); err == nil {
+		t.Error("Expected error with a key name outside the Transit mount")
+	}
+}
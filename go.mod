@@ -3,10 +3,12 @@ module github.com/ovotech/mantle
 go 1.18
 
 require (
+	filippo.io/age v1.0.0
 	github.com/aws/aws-sdk-go v1.44.171
 	github.com/jessevdk/go-flags v1.5.0
-	golang.org/x/oauth2 v0.0.0-20221014153046-6fdb5e3db783
+	github.com/miekg/pkcs11 v1.1.1
 	google.golang.org/api v0.105.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -19,7 +21,9 @@ require (
 	github.com/googleapis/gax-go/v2 v2.7.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	go.opencensus.io v0.24.0 // indirect
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
 	golang.org/x/net v0.1.0 // indirect
+	golang.org/x/oauth2 v0.0.0-20221014153046-6fdb5e3db783 // indirect
 	golang.org/x/sys v0.1.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"os"
 
 	"github.com/ovotech/mantle/crypt"
@@ -8,12 +9,37 @@ import (
 	flags "github.com/jessevdk/go-flags"
 )
 
+//exitCodes maps errors returned by the crypt package to distinct exit codes,
+//checked in order, anything else exits with 1
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{crypt.ErrCiphertextTooShort, 2},
+	{crypt.ErrInvalidCiphertext, 2},
+	{crypt.ErrUnsupportedVersion, 3},
+	{crypt.ErrAuthenticationFailed, 4},
+	{crypt.ErrKMSUnavailable, 5},
+	{crypt.ErrUnsupportedProvider, 6},
+	{crypt.ErrProviderMismatch, 6},
+}
+
 func main() {
 	if _, err := crypt.Parser.Parse(); err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
 		} else {
-			os.Exit(1)
+			os.Exit(exitCode(err))
+		}
+	}
+}
+
+//exitCode returns the exit code for an error
+func exitCode(err error) int {
+	for _, exit := range exitCodes {
+		if errors.Is(err, exit.err) {
+			return exit.code
 		}
 	}
+	return 1
 }
@@ -0,0 +1,223 @@
+#!/usr/bin/env bash
+# Runs the e2e tests against a local stand-in for the AWS and GCP KMS APIs, so
+# no cloud keys or credentials are needed
+set -e
+cd "$(dirname "$0")"
+KMS_ADDR="127.0.0.1:${MANTLE_KMS_STUB_PORT:-8099}"
+export MANTLE_AWS_KMS_ENDPOINT="http://$KMS_ADDR" MANTLE_GCP_KMS_ENDPOINT="http://$KMS_ADDR"
+export AWS_ACCESS_KEY_ID="local" AWS_SECRET_ACCESS_KEY="local" AWS_REGION="eu-west-1"
+
+go build -o kmsstub/kmsstub ./kmsstub
+kmsstub/kmsstub -addr "$KMS_ADDR" &
+KMS_PID=$!
+WORK_DIR=$(mktemp -d)
+trap 'kill $KMS_PID; rm -rf "$WORK_DIR" kmsstub/kmsstub' EXIT
+for _ in $(seq 50); do
+    (echo > "/dev/tcp/${KMS_ADDR/:/\/}") 2>/dev/null && break
+    sleep 0.1
+done
+MANTLE="$PWD/../mantle"
+cd "$WORK_DIR"
+
+check_plaintext () {
+    if [[ $1 != "helloworld" ]]
+    then
+        echo "Unexpected plaintext: $1"
+        exit 1
+    fi
+}
+
+call_mantle_cmds () {
+    echo "helloworld" > plain.txt
+    "$MANTLE" encrypt -n "$KEY_NAME" -m "$PROVIDER"
+    echo "Successfully encrypted"
+    echo "-----------------------------------------------------------"
+    "$MANTLE" reencrypt -n "$KEY_NAME" -m "$PROVIDER"
+    echo "Successfully re-encrypted"
+    echo "-----------------------------------------------------------"
+    "$MANTLE" decrypt -n "$KEY_NAME" -m "$PROVIDER"
+    check_plaintext "$(cat plain.txt)"
+    echo "Successfully decrypted"
+    echo "-----------------------------------------------------------"
+    plaintext=$(echo "helloworld" | "$MANTLE" encrypt -f - -o -n "$KEY_NAME" \
+        -m "$PROVIDER" 2>/dev/null | "$MANTLE" decrypt -f - -o -n "$KEY_NAME" \
+        -m "$PROVIDER")
+    check_plaintext "$plaintext"
+    echo "Successfully piped"
+    echo "-----------------------------------------------------------"
+    echo "helloworld" > plain.txt
+    "$MANTLE" encrypt -n "$KEY_NAME" -m "$PROVIDER"
+    "$MANTLE" decrypt
+    check_plaintext "$(cat plain.txt)"
+    echo "Successfully decrypted with the detected provider and key"
+    echo "-----------------------------------------------------------"
+    echo 'GREETING="hello world"' | "$MANTLE" encrypt -f - -t secrets.env.enc \
+        -n "$KEY_NAME" -m "$PROVIDER"
+    plaintext=$("$MANTLE" exec -f secrets.env.enc -- sh -c 'echo "$GREETING"')
+    if [[ $plaintext != "hello world" ]]
+    then
+        echo "Unexpected exec output: $plaintext"
+        exit 1
+    fi
+    if "$MANTLE" exec -f secrets.env.enc -- sh -c 'exit 3'; then
+        echo "Exec didn't return the command's exit code"
+        exit 1
+    fi
+    echo "Successfully ran a command with the decrypted secrets"
+    echo "-----------------------------------------------------------"
+    echo "helloworld" | "$MANTLE" encrypt -f - -n "$KEY_NAME" -m "$PROVIDER" \
+        --aad env=local
+    if "$MANTLE" decrypt -n "$KEY_NAME" -m "$PROVIDER" --aad env=other -r; then
+        echo "Decrypted with the wrong AAD"
+        exit 1
+    fi
+    "$MANTLE" decrypt -n "$KEY_NAME" -m "$PROVIDER" --aad env=local
+    check_plaintext "$(cat plain.txt)"
+    echo "Successfully decrypted with AAD"
+    echo "-----------------------------------------------------------"
+}
+
+"$MANTLE" keygen -t mantle.key
+export MANTLE_LOCAL_KEY_FILE="$PWD/mantle.key"
+KEY_NAME="local"
+PROVIDER="local"
+echo "-----------------------------------------------------------"
+echo "LOCAL TESTS"
+echo "-----------------------------------------------------------"
+call_mantle_cmds
+
+KEY_NAME="projects/local/locations/global/keyRings/mantle/cryptoKeys/e2e"
+PROVIDER="gcp"
+echo "-----------------------------------------------------------"
+echo "GCP LOCAL TESTS"
+echo "-----------------------------------------------------------"
+call_mantle_cmds
+
+KEY_NAME="alias/mantle-e2e"
+PROVIDER="aws"
+echo "-----------------------------------------------------------"
+echo "AWS LOCAL TESTS"
+echo "-----------------------------------------------------------"
+call_mantle_cmds
+echo "helloworld" > plain.txt
+"$MANTLE" encrypt -n "$KEY_NAME" -m "$PROVIDER" --encryptionContext service=e2e
+"$MANTLE" decrypt -m "$PROVIDER"
+check_plaintext "$(cat plain.txt)"
+echo "Successfully decrypted with the stored encryption context"
+
+# a throwaway age key pair, only used by these tests
+AGE_RECIPIENT="age1t2pxp0skyftzr7lfs5dce5qpxnj6msh2mupeqfn76wjc27grguwsc7hrw0"
+AGE_IDENTITY="AGE-SECRET-KEY-1QMG6NS5UTRMJ0Z9MCPZJFH7MTCN9WYA3R6PXKUWTCLVKLRK8ZQSSRUNQVP"
+echo "-----------------------------------------------------------"
+echo "AGE TESTS"
+echo "-----------------------------------------------------------"
+echo "helloworld" > plain.txt
+if ! "$MANTLE" encrypt -m age --ageRecipient "$AGE_RECIPIENT"; then
+    echo "Encrypting with only an age recipient failed"
+    exit 1
+fi
+echo "Successfully encrypted with only an age recipient"
+MANTLE_AGE_IDENTITY="$AGE_IDENTITY" "$MANTLE" decrypt
+check_plaintext "$(cat plain.txt)"
+echo "Successfully decrypted with the age identity"
+
+GCP_KEY_NAME="projects/local/locations/global/keyRings/mantle/cryptoKeys/e2e"
+echo "-----------------------------------------------------------"
+echo "MULTI-KEY LOCAL TESTS"
+echo "-----------------------------------------------------------"
+echo "helloworld" > plain.txt
+"$MANTLE" encrypt --key "aws:$KEY_NAME" --key "gcp:$GCP_KEY_NAME" --key local
+"$MANTLE" reencrypt --key "aws:$KEY_NAME" --key "gcp:$GCP_KEY_NAME" --key local
+echo "Successfully encrypted with several keys"
+"$MANTLE" inspect
+"$MANTLE" inspect --json | grep -q "\"keyId\": \"$GCP_KEY_NAME\""
+echo "Successfully inspected"
+"$MANTLE" decrypt -m gcp -n "$GCP_KEY_NAME" -r
+check_plaintext "$(cat plain.txt)"
+"$MANTLE" decrypt -m local -r
+check_plaintext "$(cat plain.txt)"
+"$MANTLE" decrypt --key "aws:$KEY_NAME" --key "gcp:$GCP_KEY_NAME" -r
+check_plaintext "$(cat plain.txt)"
+"$MANTLE" decrypt
+check_plaintext "$(cat plain.txt)"
+echo "Successfully decrypted with each key"
+echo "-----------------------------------------------------------"
+echo "THRESHOLD LOCAL TESTS"
+echo "-----------------------------------------------------------"
+echo "helloworld" > plain.txt
+"$MANTLE" encrypt --key "aws:$KEY_NAME" --key "gcp:$GCP_KEY_NAME" --key local \
+    --threshold 2
+echo "Successfully encrypted with 2 of 3 keys needed"
+if "$MANTLE" decrypt -m gcp -n "$GCP_KEY_NAME" -r; then
+    echo "Decrypted with too few keys"
+    exit 1
+fi
+"$MANTLE" decrypt --key "gcp:$GCP_KEY_NAME" --key local
+check_plaintext "$(cat plain.txt)"
+echo "Successfully decrypted with 2 of 3 keys"
+echo "-----------------------------------------------------------"
+echo "STRUCTURED FILE LOCAL TESTS"
+echo "-----------------------------------------------------------"
+printf 'db:\n  user: admin\n  password: helloworld\nreplicas: 3\n' > values.yaml
+"$MANTLE" encrypt -m local --format yaml --keyRegex password -f values.yaml \
+    -t values.enc.yaml -r
+if ! grep -q "replicas: 3" values.enc.yaml || grep -q "helloworld" values.enc.yaml; then
+    echo "Unexpected encrypted values:"
+    cat values.enc.yaml
+    exit 1
+fi
+"$MANTLE" reencrypt -m local --format yaml -f values.enc.yaml
+"$MANTLE" decrypt --format yaml -f values.enc.yaml -t values.out.yaml -r
+if ! cmp -s values.yaml values.out.yaml; then
+    echo "Unexpected decrypted values:"
+    cat values.out.yaml
+    exit 1
+fi
+echo "Successfully encrypted only the password"
+printf '# database\nDB_USER=admin\nDB_PASSWORD="helloworld" # rotated\n' > secrets.env
+"$MANTLE" encrypt -m local --format dotenv -f secrets.env -t secrets.enc.env -r
+if ! grep -q "^# database$" secrets.enc.env || ! grep -q '^DB_PASSWORD="mantle:v1:str:' secrets.enc.env \
+    || grep -q "helloworld" secrets.enc.env; then
+    echo "Unexpected encrypted variables:"
+    cat secrets.enc.env
+    exit 1
+fi
+check_plaintext "$("$MANTLE" exec --format dotenv -f secrets.enc.env -- sh -c 'printf %s "$DB_PASSWORD"')"
+"$MANTLE" decrypt --format dotenv -f secrets.enc.env -t secrets.out.env -r
+if ! cmp -s secrets.env secrets.out.env; then
+    echo "Unexpected decrypted variables:"
+    cat secrets.out.env
+    exit 1
+fi
+echo "Successfully encrypted each variable"
+echo "-----------------------------------------------------------"
+echo "EDIT LOCAL TESTS"
+echo "-----------------------------------------------------------"
+echo "hello" > plain.txt
+"$MANTLE" encrypt -m local -t edit.txt
+printf '#!/bin/sh\necho helloworld > "$1"\n' > editor.sh
+chmod +x editor.sh
+EDITOR="$PWD/editor.sh" "$MANTLE" edit edit.txt
+check_plaintext "$("$MANTLE" decrypt -f edit.txt -r -o 2>/dev/null)"
+echo "Successfully edited"
+echo "-----------------------------------------------------------"
+echo "GIT FILTER LOCAL TESTS"
+echo "-----------------------------------------------------------"
+mkdir repo
+cd repo
+git init -q
+"$MANTLE" -m local git-init cipher.txt
+echo "helloworld" > cipher.txt
+git add .gitattributes cipher.txt
+git -c user.name=mantle -c user.email=mantle@example.com commit -q -m "Add secret"
+if git show HEAD:cipher.txt | grep -q "helloworld" || [[ -n $(git status --porcelain) ]]; then
+    echo "Unexpected commit:"
+    git show HEAD:cipher.txt
+    git status
+    exit 1
+fi
+rm cipher.txt
+git checkout cipher.txt
+check_plaintext "$(cat cipher.txt)"
+echo "Successfully committed and checked out the encrypted file"
+cd ..
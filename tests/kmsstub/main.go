@@ -0,0 +1,216 @@
+// Copyright 2026 OVO Technology
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//Package main implements kmsstub, a local stand-in for the AWS and GCP KMS
+//APIs, used by the local e2e tests. It encrypts with an AES-GCM key generated
+//at startup, binding the key name (and any AWS encryption context) into each
+//ciphertext, so only the running process can decrypt them.
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var aesgcm cipher.AEAD
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8099", "address to listen on")
+	flag.Parse()
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		log.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err == nil {
+		aesgcm, err = cipher.NewGCM(block)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.HandleFunc("/", handle)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+//handle routes AWS requests by their X-Amz-Target header, and GCP requests by
+//their path
+func handle(w http.ResponseWriter, r *http.Request) {
+	switch target := r.Header.Get("X-Amz-Target"); {
+	case target == "TrentService.Encrypt":
+		handleAwsEncrypt(w, r)
+	case target == "TrentService.Decrypt":
+		handleAwsDecrypt(w, r)
+	case strings.HasSuffix(r.URL.Path, ":encrypt"):
+		handleGcpEncrypt(w, r)
+	case strings.HasSuffix(r.URL.Path, ":decrypt"):
+		handleGcpDecrypt(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type awsRequest struct {
+	KeyID             string `json:"KeyId"`
+	Plaintext         []byte
+	CiphertextBlob    []byte
+	EncryptionContext map[string]string
+}
+
+type awsResponse struct {
+	KeyID          string `json:"KeyId"`
+	Plaintext      []byte `json:",omitempty"`
+	CiphertextBlob []byte `json:",omitempty"`
+}
+
+func handleAwsEncrypt(w http.ResponseWriter, r *http.Request) {
+	var req awsRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	blob := seal(req.KeyID, req.Plaintext, req.EncryptionContext)
+	writeResponse(w, awsResponse{KeyID: req.KeyID, CiphertextBlob: blob})
+}
+
+func handleAwsDecrypt(w http.ResponseWriter, r *http.Request) {
+	var req awsRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	keyID, plaintext, err := open(req.CiphertextBlob, req.EncryptionContext)
+	if err != nil {
+		writeError(w, "InvalidCiphertextException", err)
+		return
+	}
+	writeResponse(w, awsResponse{KeyID: keyID, Plaintext: plaintext})
+}
+
+type gcpRequest struct {
+	Plaintext  []byte `json:"plaintext"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type gcpResponse struct {
+	Name       string `json:"name,omitempty"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+//gcpKeyName returns the key's resource ID from the request path, e.g.
+//projects/p/locations/l/keyRings/r/cryptoKeys/k from
+//v1/projects/p/locations/l/keyRings/r/cryptoKeys/k:encrypt
+func gcpKeyName(r *http.Request) string {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/")
+	return name[:strings.LastIndex(name, ":")]
+}
+
+func handleGcpEncrypt(w http.ResponseWriter, r *http.Request) {
+	var req gcpRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	name := gcpKeyName(r)
+	writeResponse(w, gcpResponse{Name: name,
+		Ciphertext: seal(name, req.Plaintext, nil)})
+}
+
+func handleGcpDecrypt(w http.ResponseWriter, r *http.Request) {
+	var req gcpRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	keyName, plaintext, err := open(req.Ciphertext, nil)
+	if err == nil && keyName != gcpKeyName(r) {
+		err = errors.New("ciphertext was encrypted with a different key")
+	}
+	if err != nil {
+		writeError(w, "INVALID_ARGUMENT", err)
+		return
+	}
+	writeResponse(w, gcpResponse{Plaintext: plaintext})
+}
+
+//seal encrypts the plaintext, returning keyNameLength[1]keyName nonce sealed
+func seal(keyName string, plaintext []byte, encryptionContext map[string]string) []byte {
+	nonce := make([]byte, aesgcm.NonceSize())
+	io.ReadFull(rand.Reader, nonce)
+	blob := append([]byte{byte(len(keyName))}, keyName...)
+	blob = append(blob, nonce...)
+	return aesgcm.Seal(blob, nonce, plaintext,
+		additionalData(keyName, encryptionContext))
+}
+
+//open decrypts a blob returned by seal, returning the key name it was
+//encrypted with
+func open(blob []byte, encryptionContext map[string]string) (string, []byte, error) {
+	if len(blob) < 1 || len(blob) < 1+int(blob[0])+aesgcm.NonceSize() {
+		return "", nil, errors.New("ciphertext too short")
+	}
+	keyName := string(blob[1 : 1+blob[0]])
+	blob = blob[1+blob[0]:]
+	plaintext, err := aesgcm.Open(nil, blob[:aesgcm.NonceSize()],
+		blob[aesgcm.NonceSize():], additionalData(keyName, encryptionContext))
+	return keyName, plaintext, err
+}
+
+//additionalData binds the key name and encryption context to the ciphertext
+func additionalData(keyName string, encryptionContext map[string]string) []byte {
+	keys := make([]string, 0, len(encryptionContext))
+	for key := range encryptionContext {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data := []string{keyName}
+	for _, key := range keys {
+		data = append(data, key+"="+encryptionContext[key])
+	}
+	encoded, _ := json.Marshal(data)
+	return encoded
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, "SerializationException", err)
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+//writeError writes an error in the form both AWS and GCP clients understand
+func writeError(w http.ResponseWriter, code string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"__type":  code,
+		"message": err.Error(),
+		"error": map[string]interface{}{
+			"code":    http.StatusBadRequest,
+			"status":  code,
+			"message": err.Error(),
+		},
+	})
+}